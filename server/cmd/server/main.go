@@ -1,23 +1,46 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/gofyr/server_monitor/server/internal/alert"
 	"github.com/gofyr/server_monitor/server/internal/auth"
+	"github.com/gofyr/server_monitor/server/internal/checks"
+	"github.com/gofyr/server_monitor/server/internal/collector"
 	"github.com/gofyr/server_monitor/server/internal/config"
+	"github.com/gofyr/server_monitor/server/internal/diag"
+	"github.com/gofyr/server_monitor/server/internal/forecast"
 	"github.com/gofyr/server_monitor/server/internal/handlers"
+	"github.com/gofyr/server_monitor/server/internal/heartbeat"
+	"github.com/gofyr/server_monitor/server/internal/history"
+	"github.com/gofyr/server_monitor/server/internal/ingest"
 	"github.com/gofyr/server_monitor/server/internal/middleware"
+	"github.com/gofyr/server_monitor/server/internal/notify"
+	"github.com/gofyr/server_monitor/server/internal/otlp"
+	"github.com/gofyr/server_monitor/server/internal/recorder"
+	"github.com/gofyr/server_monitor/server/internal/sampler"
+	"github.com/gofyr/server_monitor/server/internal/scrape"
+	"github.com/gofyr/server_monitor/server/internal/sessions"
+	"github.com/gofyr/server_monitor/server/internal/tokens"
+	"github.com/gofyr/server_monitor/server/internal/tsdb"
+	"github.com/gofyr/server_monitor/server/internal/users"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "user" {
+		os.Exit(userCommand(os.Args[2:]))
+	}
+
 	cfgPath := flag.String("config", "", "Path to server config file (yaml)")
 	genCert := flag.Bool("gen-cert", false, "Generate self-signed TLS certificate in data dir and exit")
 	hashSecret := flag.String("hash", "", "Print bcrypt hash of the provided secret and exit")
@@ -51,6 +74,124 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to init auth: %v", err)
 	}
+	accounts, err := users.Open(filepath.Join(cfg.DataDir, "users.json"), cfg)
+	if err != nil {
+		log.Fatalf("failed to load users: %v", err)
+	}
+	sess, err := sessions.Open(filepath.Join(cfg.DataDir, "sessions.json"), cfg.RefreshTTL)
+	if err != nil {
+		log.Fatalf("failed to load sessions: %v", err)
+	}
+	apiTokens, err := tokens.Open(filepath.Join(cfg.DataDir, "tokens.json"))
+	if err != nil {
+		log.Fatalf("failed to load API tokens: %v", err)
+	}
+
+	ctx := context.Background()
+
+	// Collectors run on their own schedules; a single background sampler
+	// assembles their results for every metrics consumer
+	reg := collector.NewRegistry(cfg)
+	// Only the Prometheus endpoint reads the periodic process list
+	collector.RegisterBuiltin(reg, cfg.SampleInterval, cfg.Prometheus.Enabled && cfg.Prometheus.TopProcesses > 0)
+	checkRunner, err := checks.NewRunner(reg, cfg.Checks)
+	if err != nil {
+		log.Fatalf("invalid checks: %v", err)
+	}
+	scraper, err := scrape.New(reg, cfg.Scrape)
+	if err != nil {
+		log.Fatalf("invalid scrape targets: %v", err)
+	}
+	go reg.Run(ctx)
+	hub := sampler.NewHub()
+	smp := sampler.New(cfg.SampleInterval, hub, reg)
+	smp.AddSource(checkRunner)
+	smp.AddSource(scraper)
+	agg := ingest.NewAggregator(cfg.Ingest.FlushInterval, cfg.Ingest.MaxSeries)
+	agg.Reserve(smp.Reserved)
+	go agg.Run(ctx)
+	smp.AddSource(agg)
+	if cfg.Ingest.StatsD.Enabled {
+		if err := ingest.ListenStatsD(ctx, cfg.Ingest.StatsD.Address, agg); err != nil {
+			log.Fatalf("failed to start statsd listener: %v", err)
+		}
+	}
+	heartbeats, err := heartbeat.NewMonitor(filepath.Join(cfg.DataDir, "heartbeats.json"), cfg.Heartbeats)
+	if err != nil {
+		log.Fatalf("invalid heartbeats: %v", err)
+	}
+	smp.AddSource(heartbeats)
+	go smp.Run(ctx)
+	ring := history.NewRing(int(cfg.HistoryRetention / cfg.SampleInterval))
+	go ring.Run(ctx, hub)
+	var db *tsdb.DB
+	if cfg.Storage.Enabled {
+		tiers := make([]tsdb.Tier, 0, len(cfg.Storage.Tiers))
+		for _, t := range cfg.Storage.Tiers {
+			tiers = append(tiers, tsdb.Tier{Resolution: t.Resolution, Retention: t.Retention})
+		}
+		db, err = tsdb.Open(filepath.Join(cfg.DataDir, "tsdb"), tiers)
+		if err != nil {
+			log.Fatalf("failed to open metrics store: %v", err)
+		}
+		go db.Run(ctx, hub)
+	}
+	silences, err := alert.OpenSilences(filepath.Join(cfg.DataDir, "silences.json"), cfg.Alerts.Maintenance)
+	if err != nil {
+		log.Fatalf("invalid silences: %v", err)
+	}
+	rules := cfg.Alerts.Rules
+	if !hasRule(rules, heartbeat.AlertRule().Name) {
+		rules = append(rules, heartbeat.AlertRule())
+	}
+	fc := forecast.New(db, ring)
+	alerts, err := alert.NewEngine(rules, silences, db, fc)
+	if err != nil {
+		log.Fatalf("invalid alert rules: %v", err)
+	}
+	alertHistory, err := alert.OpenHistory(filepath.Join(cfg.DataDir, "alerts.jsonl"), cfg.Alerts.HistoryRetention)
+	if err != nil {
+		log.Fatalf("failed to open alert history: %v", err)
+	}
+	alerts.Restore(alertHistory.Unresolved())
+	alerts.AddListener(alertHistory.Record)
+	snapshots, err := diag.Open(filepath.Join(cfg.DataDir, "snapshots"), cfg.Alerts.HistoryRetention, reg)
+	if err != nil {
+		log.Fatalf("failed to open snapshot store: %v", err)
+	}
+	alerts.OnFire(snapshots.Trigger)
+	go snapshots.Run(ctx)
+	var rec *recorder.Recorder
+	if cfg.Recorder.Enabled {
+		rec, err = recorder.New(filepath.Join(cfg.DataDir, "recordings"), cfg.Recorder)
+		if err != nil {
+			log.Fatalf("invalid recorder config: %v", err)
+		}
+		alerts.AddListener(rec.Notify)
+		go rec.Run(ctx)
+	}
+	refs, err := notify.OpenRefs(filepath.Join(cfg.DataDir, "notify-refs.json"))
+	if err != nil {
+		log.Fatalf("failed to load notification refs: %v", err)
+	}
+	channels, err := notify.Channels(cfg.Notify, refs)
+	if err != nil {
+		log.Fatalf("invalid notify config: %v", err)
+	}
+	dispatcher, err := notify.NewDispatcher(filepath.Join(cfg.DataDir, "outbox"), cfg.Notify.MaxAge, channels)
+	if err != nil {
+		log.Fatalf("failed to open notification outbox: %v", err)
+	}
+	alerts.AddListener(dispatcher.Notify)
+	go dispatcher.Run(ctx)
+	go alerts.Run(ctx, hub)
+	if cfg.OTLP.Enabled {
+		exp, err := otlp.NewExporter(cfg.OTLP)
+		if err != nil {
+			log.Fatalf("invalid otlp config: %v", err)
+		}
+		go exp.Run(ctx, hub)
+	}
 
 	r := mux.NewRouter()
 	r.Use(middleware.SecurityHeaders())
@@ -60,41 +201,98 @@ func main() {
 		r.Use(middleware.CIDRAllowlist(cfg.AllowedCIDRs))
 	}
 
+	// Heartbeat pings carry their own token and come from jobs that cannot
+	// log in or present the client key
+	r.HandleFunc("/api/heartbeat/{id}", handlers.HeartbeatPingHandler(heartbeats)).Methods(http.MethodPost)
+	r.HandleFunc("/api/heartbeat/{id}/{kind:start|success|fail}", handlers.HeartbeatPingHandler(heartbeats)).Methods(http.MethodPost)
+
 	api := r.PathPrefix("/api").Subrouter()
 	// Require client key header if configured (supports hash)
 	api.Use(middleware.HashedClientKey(cfg))
 
 	// Auth endpoints
-	api.HandleFunc("/auth/login", handlers.LoginHandler(jwtManager, cfg)).Methods(http.MethodPost)
-	api.HandleFunc("/auth/refresh", handlers.RefreshHandler(jwtManager)).Methods(http.MethodPost)
+	api.HandleFunc("/auth/login", handlers.LoginHandler(jwtManager, accounts, sess)).Methods(http.MethodPost)
+	api.HandleFunc("/auth/refresh", handlers.RefreshHandler(jwtManager, accounts, sess)).Methods(http.MethodPost)
 
 	// Protected endpoints
 	protected := api.NewRoute().Subrouter()
-	protected.Use(middleware.JWTAuth(jwtManager))
+	protected.Use(middleware.JWTAuth(jwtManager, sess, apiTokens, accounts))
+	// Viewers can read everything; acting needs operator, managing access
+	// needs admin
+	operator := middleware.RequireRole(auth.RoleOperator)
+	admin := middleware.RequireRole(auth.RoleAdmin)
 	protected.HandleFunc("/me", handlers.MeHandler(cfg)).Methods(http.MethodGet)
-	protected.HandleFunc("/metrics", handlers.MetricsHandler()).Methods(http.MethodGet)
-	protected.HandleFunc("/metrics/stream", handlers.MetricsSSEHandler()).Methods(http.MethodGet)
+	protected.HandleFunc("/metrics", handlers.MetricsHandler(hub)).Methods(http.MethodGet)
+	protected.HandleFunc("/metrics/stream", handlers.MetricsSSEHandler(hub)).Methods(http.MethodGet)
+	protected.HandleFunc("/metrics/series", handlers.MetricsSeriesHandler(hub)).Methods(http.MethodGet)
+	protected.HandleFunc("/metrics/history", handlers.MetricsHistoryHandler(ring, db)).Methods(http.MethodGet)
 	protected.HandleFunc("/processes", handlers.ProcessesHandler()).Methods(http.MethodGet)
-	protected.HandleFunc("/services", handlers.ServicesHandler()).Methods(http.MethodGet)
-	protected.HandleFunc("/system/detail", handlers.SystemDetailHandler()).Methods(http.MethodGet)
-	protected.HandleFunc("/network/detail", handlers.NetworkDetailHandler()).Methods(http.MethodGet)
-	protected.HandleFunc("/disk/detail", handlers.DiskDetailHandler()).Methods(http.MethodGet)
-	protected.HandleFunc("/containers", handlers.ContainersHandler()).Methods(http.MethodGet)
+	protected.HandleFunc("/services", handlers.ServicesHandler(reg)).Methods(http.MethodGet)
+	protected.HandleFunc("/system/detail", handlers.SystemDetailHandler(hub)).Methods(http.MethodGet)
+	protected.HandleFunc("/network/detail", handlers.NetworkDetailHandler(hub)).Methods(http.MethodGet)
+	protected.HandleFunc("/disk/detail", handlers.DiskDetailHandler(hub, fc)).Methods(http.MethodGet)
+	protected.HandleFunc("/containers", handlers.ContainersHandler(reg)).Methods(http.MethodGet)
+	protected.Handle("/ingest", operator(handlers.IngestHandler(agg))).Methods(http.MethodPost)
+	protected.HandleFunc("/checks", handlers.ChecksHandler(checkRunner)).Methods(http.MethodGet)
+	protected.HandleFunc("/alerts", handlers.AlertsHandler(alerts)).Methods(http.MethodGet)
+	protected.HandleFunc("/alerts/history", handlers.AlertHistoryHandler(alertHistory)).Methods(http.MethodGet)
+	protected.HandleFunc("/alerts/stream", handlers.AlertStreamHandler(alerts)).Methods(http.MethodGet)
+	protected.HandleFunc("/alerts/baselines", handlers.AlertBaselinesHandler(alerts)).Methods(http.MethodGet)
+	protected.Handle("/alerts/{id}/ack", operator(handlers.AckAlertHandler(alerts))).Methods(http.MethodPost)
+	protected.HandleFunc("/alerts/{id}/snapshot", handlers.AlertSnapshotHandler(snapshots)).Methods(http.MethodGet)
+	protected.HandleFunc("/heartbeats", handlers.HeartbeatsHandler(heartbeats)).Methods(http.MethodGet)
+	protected.Handle("/heartbeats", operator(handlers.CreateHeartbeatHandler(heartbeats))).Methods(http.MethodPost)
+	protected.Handle("/heartbeats/{id}", operator(handlers.DeleteHeartbeatHandler(heartbeats))).Methods(http.MethodDelete)
+	protected.HandleFunc("/silences", handlers.SilencesHandler(silences)).Methods(http.MethodGet)
+	protected.Handle("/silences", operator(handlers.CreateSilenceHandler(silences))).Methods(http.MethodPost)
+	protected.Handle("/silences/{id}", operator(handlers.DeleteSilenceHandler(silences))).Methods(http.MethodDelete)
+	if rec != nil {
+		protected.Handle("/recorder/dump", operator(handlers.CreateRecorderDumpHandler(rec))).Methods(http.MethodPost)
+		protected.HandleFunc("/recorder/dumps", handlers.RecorderDumpsHandler(rec)).Methods(http.MethodGet)
+		protected.HandleFunc("/recorder/dumps/{id}", handlers.RecorderDumpHandler(rec)).Methods(http.MethodGet)
+	}
+	protected.HandleFunc("/collectors", handlers.CollectorsHandler(reg)).Methods(http.MethodGet)
 	protected.HandleFunc("/logins", handlers.LoginsHandler()).Methods(http.MethodGet)
-	protected.HandleFunc("/auth/change_credentials", handlers.ChangeCredentialsHandler(jwtManager, cfg)).Methods(http.MethodPost)
+	protected.Handle("/auth/change_credentials", admin(handlers.ChangeCredentialsHandler(jwtManager, cfg, accounts))).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/logout", handlers.LogoutHandler(sess)).Methods(http.MethodPost)
+	protected.HandleFunc("/sessions", handlers.SessionsHandler(sess)).Methods(http.MethodGet)
+	protected.HandleFunc("/sessions/{id}", handlers.DeleteSessionHandler(sess)).Methods(http.MethodDelete)
+	protected.HandleFunc("/tokens", handlers.TokensHandler(apiTokens)).Methods(http.MethodGet)
+	protected.HandleFunc("/tokens", handlers.CreateTokenHandler(apiTokens)).Methods(http.MethodPost)
+	protected.HandleFunc("/tokens/{id}", handlers.RevokeTokenHandler(apiTokens)).Methods(http.MethodDelete)
+	protected.Handle("/users", admin(handlers.UsersHandler(accounts))).Methods(http.MethodGet)
+	protected.Handle("/users", admin(handlers.CreateUserHandler(accounts))).Methods(http.MethodPost)
+	protected.Handle("/users/{username}", admin(handlers.UpdateUserHandler(accounts))).Methods(http.MethodPatch)
+	protected.Handle("/users/{username}", admin(handlers.DeleteUserHandler(accounts, sess, apiTokens))).Methods(http.MethodDelete)
+
+	// Prometheus scrape endpoint with its own auth; scrapers cannot log in
+	if cfg.Prometheus.Enabled {
+		pc := cfg.Prometheus
+		if pc.BearerToken == "" && pc.BearerTokenHash == "" && !pc.RequireClientCert {
+			log.Fatalf("prometheus endpoint needs a bearer token or require_client_cert")
+		}
+		if pc.RequireClientCert && cfg.ClientCAPath == "" {
+			log.Fatalf("prometheus require_client_cert needs client_ca_path")
+		}
+		r.Handle("/metrics", middleware.ScrapeAuth(pc)(handlers.PrometheusHandler(hub, reg, pc.TopProcesses))).Methods(http.MethodGet)
+	}
 
 	// Health
 	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }).Methods(http.MethodGet)
 
 	tlsConf := &tls.Config{MinVersion: tls.VersionTLS12}
-	// Optional mTLS
-	if cfg.RequireClientCA && cfg.ClientCAPath != "" {
+	// Optional mTLS, either for every request or only offered so /metrics
+	// can authenticate scrapers by certificate
+	if cfg.ClientCAPath != "" && (cfg.RequireClientCA || cfg.Prometheus.RequireClientCert) {
 		caPool, err := middleware.LoadCAPool(cfg.ClientCAPath)
 		if err != nil {
 			log.Fatalf("failed to load client CA: %v", err)
 		}
 		tlsConf.ClientCAs = caPool
-		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConf.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.RequireClientCA {
+			tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+		}
 	}
 
 	srv := &http.Server{
@@ -119,3 +317,12 @@ func main() {
 		log.Fatalf("server error: %v", err)
 	}
 }
+
+func hasRule(rules []config.AlertRule, name string) bool {
+	for _, r := range rules {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
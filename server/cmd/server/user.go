@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gofyr/server_monitor/server/internal/auth"
+	"github.com/gofyr/server_monitor/server/internal/config"
+	"github.com/gofyr/server_monitor/server/internal/sessions"
+	"github.com/gofyr/server_monitor/server/internal/tokens"
+	"github.com/gofyr/server_monitor/server/internal/users"
+)
+
+const userUsage = `usage:
+  server user add [-config path] [-role viewer|operator|admin] [-password pw] <username>
+  server user list [-config path]
+  server user delete [-config path] <username>
+
+Without -password, add reads the password from the first line of stdin.
+`
+
+// userCommand manages the user store of the configured data_dir. A running
+// server picks up the changes on the next request.
+func userCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, userUsage)
+		return 2
+	}
+	fs := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	cfgPath := fs.String("config", "", "Path to server config file (yaml)")
+	role := fs.String("role", auth.RoleViewer, "Role of the new user")
+	password := fs.String("password", "", "Password of the new user")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, userUsage) }
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	cfg, err := config.Load(*cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return 1
+	}
+	accounts, err := users.Open(filepath.Join(cfg.DataDir, "users.json"), cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load users: %v\n", err)
+		return 1
+	}
+	switch {
+	case args[0] == "add" && fs.NArg() == 1:
+		pw := *password
+		if pw == "" {
+			fmt.Fprint(os.Stderr, "Password: ")
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				fmt.Fprintf(os.Stderr, "failed to read password: %v\n", err)
+				return 1
+			}
+			pw = strings.TrimRight(line, "\r\n")
+		}
+		u, err := accounts.Add(fs.Arg(0), pw, *role)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "add user: %v\n", err)
+			return 1
+		}
+		fmt.Printf("added %s (%s)\n", u.Username, u.Role)
+	case args[0] == "list" && fs.NArg() == 0:
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "USERNAME\tROLE\tSOURCE")
+		for _, u := range accounts.List() {
+			source := "users.json"
+			if u.Configured {
+				source = "config"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", u.Username, u.Role, source)
+		}
+		tw.Flush()
+	case args[0] == "delete" && fs.NArg() == 1:
+		if err := accounts.Delete(fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "delete user: %v\n", err)
+			return 1
+		}
+		// A running server keeps its own copy of these, but it already
+		// rejects them once the account is gone or re-created.
+		sess, err := sessions.Open(filepath.Join(cfg.DataDir, "sessions.json"), cfg.RefreshTTL)
+		if err == nil {
+			err = sess.RevokeUser(fs.Arg(0))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "revoke sessions: %v\n", err)
+			return 1
+		}
+		apiTokens, err := tokens.Open(filepath.Join(cfg.DataDir, "tokens.json"))
+		if err == nil {
+			err = apiTokens.RevokeUser(fs.Arg(0))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "revoke API tokens: %v\n", err)
+			return 1
+		}
+		fmt.Printf("deleted %s\n", fs.Arg(0))
+	default:
+		fmt.Fprint(os.Stderr, userUsage)
+		return 2
+	}
+	return 0
+}
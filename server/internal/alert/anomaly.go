@@ -0,0 +1,277 @@
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/tsdb"
+)
+
+const (
+	defaultTau = time.Hour
+	// Samples needed before a series can be judged
+	minSamples = 30
+	// Weeks of history behind the hour-of-week profile
+	seasonWeeks   = 4
+	seasonRefresh = 6 * time.Hour
+	// Band points kept per series for charts
+	bandPoints = 1800
+)
+
+// Band is the learned baseline of a series at one point in time. Lower and
+// Upper are Sigma standard deviations around Mean.
+type Band struct {
+	Time   time.Time `json:"time"`
+	Value  float64   `json:"value"`
+	Mean   float64   `json:"mean"`
+	StdDev float64   `json:"stddev"`
+	Lower  float64   `json:"lower"`
+	Upper  float64   `json:"upper"`
+	Z      float64   `json:"z"`
+}
+
+// Baseline describes one series watched by an anomaly rule.
+type Baseline struct {
+	Rule     string  `json:"rule"`
+	Series   string  `json:"series"`
+	Sigma    float64 `json:"sigma"`
+	Seasonal bool    `json:"seasonal"`
+	Ready    bool    `json:"ready"`
+	Current  *Band   `json:"current,omitempty"`
+	Points   []Band  `json:"points,omitempty"`
+}
+
+// ewma tracks an exponentially weighted mean and variance of the residual
+// after removing the seasonal profile.
+type ewma struct {
+	mean, variance float64
+	n              int
+	last           time.Time
+	bands          []Band
+	profile        *profile
+	missed         int // consecutive evaluations without the series
+}
+
+// profile is the average value per hour of the week from stored history.
+type profile struct {
+	avg     [168]float64
+	ok      [168]bool
+	fetched time.Time
+}
+
+func hourOfWeek(t time.Time) int {
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+// anomaly compares each selected series with its baseline; the condition
+// value is the z-score of the current sample.
+type anomaly struct {
+	sel       selector
+	op        string
+	threshold float64
+	// Band half-width in standard deviations, |threshold|
+	sigma    float64
+	tau      time.Duration
+	seasonal bool
+	db       *tsdb.DB
+
+	mu    sync.Mutex
+	state map[string]*ewma
+}
+
+// parseAnomaly parses the arguments of anomaly(<selector>[, seasonal][, <tau>]).
+func parseAnomaly(args string, db *tsdb.DB) (*anomaly, error) {
+	parts := splitArgs(args)
+	sel, err := parseSelector(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	a := &anomaly{sel: sel, tau: defaultTau, db: db, state: map[string]*ewma{}}
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if p == "seasonal" {
+			a.seasonal = true
+			continue
+		}
+		d, err := time.ParseDuration(p)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("anomaly: unknown argument %q", p)
+		}
+		a.tau = d
+	}
+	if a.seasonal && db == nil {
+		return nil, errors.New("anomaly: seasonal baselines need storage enabled")
+	}
+	return a, nil
+}
+
+// splitArgs splits on commas outside quotes and braces.
+func splitArgs(s string) []string {
+	var out []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			out = append(out, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(out, s[start:])
+}
+
+func (a *anomaly) eval(now time.Time, values map[string]float64) map[string]result {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := map[string]result{}
+	for key, v := range a.sel.selectFrom(values) {
+		st := a.state[key]
+		if st == nil {
+			st = &ewma{}
+			a.state[key] = st
+			a.seed(key, st, now)
+		}
+		st.missed = 0
+		season := a.season(key, st, now)
+		resid := v - season
+		band := Band{Time: now, Value: v, Mean: season + st.mean}
+		var z float64
+		ready := st.n >= minSamples
+		if ready {
+			sd := math.Sqrt(st.variance)
+			band.StdDev = sd
+			band.Lower = band.Mean - a.sigma*sd
+			band.Upper = band.Mean + a.sigma*sd
+			if sd > 0 {
+				z = (resid - st.mean) / sd
+			}
+			band.Z = z
+			st.bands = append(st.bands, band)
+			if len(st.bands) > bandPoints {
+				st.bands = append(st.bands[:0], st.bands[len(st.bands)-bandPoints:]...)
+			}
+		}
+		st.update(resid, now, a.tau)
+		out[key] = result{value: z, active: ready && compare(z, a.op, a.threshold)}
+	}
+	// Baselines outlive a few missing samples, like the alerts built on them
+	for key, st := range a.state {
+		if _, ok := out[key]; !ok {
+			st.missed++
+			if st.missed >= staleEvals {
+				delete(a.state, key)
+			}
+		}
+	}
+	return out
+}
+
+func (st *ewma) update(x float64, t time.Time, tau time.Duration) {
+	if st.n == 0 {
+		st.mean, st.variance, st.n, st.last = x, 0, 1, t
+		return
+	}
+	dt := t.Sub(st.last)
+	if dt <= 0 {
+		return
+	}
+	// Equal weights until the window fills, so early estimates are not
+	// biased towards the first sample
+	alpha := max(1-math.Exp(-dt.Seconds()/tau.Seconds()), 1/float64(st.n+1))
+	diff := x - st.mean
+	st.mean += alpha * diff
+	st.variance = (1 - alpha) * (st.variance + alpha*diff*diff)
+	st.n++
+	st.last = t
+}
+
+// seed warms a new baseline up from stored history, so it is usable right
+// after a restart.
+func (a *anomaly) seed(key string, st *ewma, now time.Time) {
+	if a.db == nil {
+		return
+	}
+	res, err := a.db.Query([]string{key}, now.Add(-3*a.tau), now, time.Minute)
+	if err != nil {
+		return
+	}
+	for _, p := range res[key] {
+		st.update(p.Avg-a.season(key, st, p.Time), p.Time, a.tau)
+	}
+}
+
+// season returns the hour-of-week average for t, or 0 without seasonality
+// or history for that hour.
+func (a *anomaly) season(key string, st *ewma, t time.Time) float64 {
+	if !a.seasonal {
+		return 0
+	}
+	if st.profile == nil || time.Since(st.profile.fetched) > seasonRefresh {
+		st.profile = a.loadProfile(key)
+	}
+	h := hourOfWeek(t)
+	if !st.profile.ok[h] {
+		return 0
+	}
+	return st.profile.avg[h]
+}
+
+func (a *anomaly) loadProfile(key string) *profile {
+	p := &profile{fetched: time.Now()}
+	to := time.Now().Truncate(time.Hour)
+	res, err := a.db.Query([]string{key}, to.Add(-seasonWeeks*7*24*time.Hour), to, time.Hour)
+	if err != nil {
+		log.Printf("alert: loading seasonal profile of %s: %v", key, err)
+		return p
+	}
+	var sum [168]float64
+	var n [168]int
+	for _, pt := range res[key] {
+		h := hourOfWeek(pt.Time)
+		sum[h] += pt.Avg
+		n[h]++
+	}
+	// Only use the profile once every hour of the week has history;
+	// partial profiles would shift the baseline at hour boundaries
+	for h := range sum {
+		if n[h] == 0 {
+			return p
+		}
+	}
+	for h := range sum {
+		p.avg[h] = sum[h] / float64(n[h])
+		p.ok[h] = true
+	}
+	return p
+}
+
+func (a *anomaly) baselines(rule string, withPoints bool) []Baseline {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]Baseline, 0, len(a.state))
+	for key, st := range a.state {
+		b := Baseline{Rule: rule, Series: key, Sigma: a.sigma, Seasonal: a.seasonal, Ready: st.n >= minSamples}
+		if len(st.bands) > 0 {
+			cur := st.bands[len(st.bands)-1]
+			b.Current = &cur
+			if withPoints {
+				b.Points = append([]Band(nil), st.bands...)
+			}
+		}
+		out = append(out, b)
+	}
+	return out
+}
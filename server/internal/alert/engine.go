@@ -0,0 +1,451 @@
+// Package alert evaluates alert rules from the config against the sampled
+// metrics and tracks the state of each alert.
+package alert
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/config"
+	"github.com/gofyr/server_monitor/server/internal/forecast"
+	"github.com/gofyr/server_monitor/server/internal/sampler"
+	"github.com/gofyr/server_monitor/server/internal/series"
+	"github.com/gofyr/server_monitor/server/internal/tsdb"
+)
+
+type State string
+
+const (
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// Resolved alerts stay visible for this long.
+const resolvedRetention = time.Hour
+
+// An alert whose series is missing from this many evaluations in a row is
+// resolved. A single failed or slow collector leaves its series out of a
+// snapshot, which must not resolve and re-fire the alerts based on them.
+const staleEvals = 5
+
+var severities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+// Alert is one occurrence of a rule matching a series. The labels include
+// the series labels, the rule labels, alertname and severity.
+type Alert struct {
+	ID          string            `json:"id"`
+	Rule        string            `json:"rule"`
+	Series      string            `json:"series"`
+	State       State             `json:"state"`
+	Severity    string            `json:"severity"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Value       float64           `json:"value"`
+	ActiveAt    time.Time         `json:"active_at"`
+	FiredAt     *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time        `json:"resolved_at,omitempty"`
+	// Silences and maintenance windows muting this alert's notifications
+	SilencedBy []string   `json:"silenced_by,omitempty"`
+	AckedBy    string     `json:"acked_by,omitempty"`
+	AckedAt    *time.Time `json:"acked_at,omitempty"`
+	AckComment string     `json:"ack_comment,omitempty"`
+	// Path of the diagnostic snapshot captured when the alert fired
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Evaluations since the series was last seen
+	missed int
+}
+
+// EventAcknowledged is the event type sent when someone acknowledges an
+// alert; the other types are the alert states.
+const EventAcknowledged = "acknowledged"
+
+var (
+	ErrNotFound = errors.New("alert not found")
+	ErrResolved = errors.New("alert already resolved")
+)
+
+// Event reports a state transition of an alert.
+type Event struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Alert Alert     `json:"alert"`
+}
+
+type rule struct {
+	cfg         config.AlertRule
+	cond        condition
+	wait        time.Duration
+	annotations map[string]*template.Template
+}
+
+// Engine evaluates every rule on each published snapshot.
+type Engine struct {
+	rules    []*rule
+	silences *Silences
+
+	mu        sync.Mutex
+	active    map[string]*Alert // by rule and series
+	resolved  []*Alert
+	listeners []func(Event)
+	subs      map[chan Event]struct{}
+	onFire    func(Alert) string
+}
+
+// NewEngine compiles rules. silences may be nil, and so may db and fc when
+// no rule needs stored history or trends.
+func NewEngine(rules []config.AlertRule, silences *Silences, db *tsdb.DB, fc *forecast.Forecaster) (*Engine, error) {
+	e := &Engine{active: map[string]*Alert{}, silences: silences, subs: map[chan Event]struct{}{}}
+	seen := map[string]bool{}
+	for _, rc := range rules {
+		if rc.Name == "" || rc.Expr == "" {
+			return nil, fmt.Errorf("alert: name and expr are required")
+		}
+		if seen[rc.Name] {
+			return nil, fmt.Errorf("alert: duplicate rule %q", rc.Name)
+		}
+		seen[rc.Name] = true
+		r, err := newRule(rc, db, fc)
+		if err != nil {
+			return nil, fmt.Errorf("alert: rule %q: %w", rc.Name, err)
+		}
+		e.rules = append(e.rules, r)
+	}
+	return e, nil
+}
+
+func newRule(rc config.AlertRule, db *tsdb.DB, fc *forecast.Forecaster) (*rule, error) {
+	if rc.Severity == "" {
+		rc.Severity = "warning"
+	}
+	if !severities[rc.Severity] {
+		return nil, fmt.Errorf("unknown severity %q", rc.Severity)
+	}
+	cond, wait, err := parseExpr(rc.Expr, db, fc)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 && rc.For > 0 {
+		return nil, fmt.Errorf("duration given both in expr and for")
+	}
+	if rc.For > 0 {
+		wait = rc.For
+	}
+	r := &rule{cfg: rc, cond: cond, wait: wait, annotations: map[string]*template.Template{}}
+	for k, text := range rc.Annotations {
+		t, err := template.New(k).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("annotation %q: %w", k, err)
+		}
+		r.annotations[k] = t
+	}
+	return r, nil
+}
+
+// AddListener registers fn to be called for every alert transition. It
+// must not block; listeners run on the evaluation goroutine.
+func (e *Engine) AddListener(fn func(Event)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, fn)
+}
+
+// OnFire registers fn to run when an alert starts firing, before the event
+// is published. The returned link is stored as the alert's Snapshot. fn is
+// called with the engine locked and must not block.
+func (e *Engine) OnFire(fn func(Alert) string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onFire = fn
+}
+
+// Subscribe returns a channel of alert events for a live stream. Events
+// are dropped for subscribers that fall behind.
+func (e *Engine) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 16)
+	e.mu.Lock()
+	e.subs[ch] = struct{}{}
+	e.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			e.mu.Lock()
+			delete(e.subs, ch)
+			e.mu.Unlock()
+		})
+	}
+}
+
+// Restore resumes alerts that were firing before a restart, so they keep
+// their IDs and resolve normally instead of firing again. Alerts of rules
+// that no longer exist are ignored.
+func (e *Engine) Restore(alerts []Alert) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, a := range alerts {
+		if a.State != StateFiring {
+			continue
+		}
+		for _, r := range e.rules {
+			if r.cfg.Name == a.Rule {
+				a := a
+				e.active[a.Rule+"\x00"+a.Series] = &a
+				break
+			}
+		}
+	}
+}
+
+// Ack acknowledges a pending or firing alert.
+func (e *Engine) Ack(id, user, comment string) (Alert, error) {
+	e.mu.Lock()
+	var found *Alert
+	for _, a := range e.active {
+		if a.ID == id {
+			found = a
+		}
+	}
+	if found == nil {
+		for _, a := range e.resolved {
+			if a.ID == id {
+				e.mu.Unlock()
+				return Alert{}, ErrResolved
+			}
+		}
+		e.mu.Unlock()
+		return Alert{}, ErrNotFound
+	}
+	now := time.Now()
+	found.AckedBy = user
+	found.AckedAt = &now
+	found.AckComment = comment
+	ev := Event{Type: EventAcknowledged, Time: now, Alert: *found}
+	e.mu.Unlock()
+	e.publish([]Event{ev})
+	return ev.Alert, nil
+}
+
+// Run evaluates the rules against snapshots published on hub until ctx is
+// cancelled.
+func (e *Engine) Run(ctx context.Context, hub *sampler.Hub) {
+	ch, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case snap := <-ch:
+			e.Eval(snap.Time, snap.Series())
+		}
+	}
+}
+
+// Eval runs one evaluation round at now.
+func (e *Engine) Eval(now time.Time, values map[string]float64) {
+	e.mu.Lock()
+	var events []Event
+	emit := func(a *Alert) {
+		events = append(events, Event{Type: string(a.State), Time: now, Alert: *a})
+	}
+	for _, r := range e.rules {
+		results := r.cond.eval(now, values)
+		for key, res := range results {
+			fp := r.cfg.Name + "\x00" + key
+			a := e.active[fp]
+			if a != nil {
+				a.missed = 0
+			}
+			if !res.active {
+				if a != nil {
+					a.Value = res.value
+					e.deactivate(fp, a, now, emit)
+				}
+				continue
+			}
+			isNew := a == nil
+			if isNew {
+				a = newAlert(r, key, now)
+				e.active[fp] = a
+			}
+			a.Value = res.value
+			wasSilenced := len(a.SilencedBy) > 0
+			a.SilencedBy = e.silences.silencedBy(a.Labels, now)
+			r.annotate(a)
+			if isNew && r.wait > 0 {
+				emit(a)
+			}
+			if a.State == StatePending && now.Sub(a.ActiveAt) >= r.wait {
+				a.State = StateFiring
+				t := now
+				a.FiredAt = &t
+				if e.onFire != nil {
+					a.Snapshot = e.onFire(*a)
+				}
+				emit(a)
+			} else if a.State == StateFiring && wasSilenced && len(a.SilencedBy) == 0 {
+				// The silence ran out while firing; announce it now
+				emit(a)
+			}
+		}
+		// Series that stay missing no longer match
+		prefix := r.cfg.Name + "\x00"
+		for fp, a := range e.active {
+			if strings.HasPrefix(fp, prefix) {
+				if _, ok := results[a.Series]; !ok {
+					a.missed++
+					if a.missed >= staleEvals {
+						e.deactivate(fp, a, now, emit)
+					}
+				}
+			}
+		}
+	}
+	kept := e.resolved[:0]
+	for _, a := range e.resolved {
+		if now.Sub(*a.ResolvedAt) < resolvedRetention {
+			kept = append(kept, a)
+		}
+	}
+	e.resolved = kept
+	e.mu.Unlock()
+	e.publish(events)
+}
+
+func (e *Engine) publish(events []Event) {
+	e.mu.Lock()
+	listeners := e.listeners
+	subs := make([]chan Event, 0, len(e.subs))
+	for ch := range e.subs {
+		subs = append(subs, ch)
+	}
+	e.mu.Unlock()
+	for _, ev := range events {
+		for _, fn := range listeners {
+			fn(ev)
+		}
+		for _, ch := range subs {
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+	}
+}
+
+// deactivate drops a pending alert or resolves a firing one.
+func (e *Engine) deactivate(fp string, a *Alert, now time.Time, emit func(*Alert)) {
+	delete(e.active, fp)
+	if a.State != StateFiring {
+		return
+	}
+	a.SilencedBy = e.silences.silencedBy(a.Labels, now)
+	a.State = StateResolved
+	t := now
+	a.ResolvedAt = &t
+	e.resolved = append(e.resolved, a)
+	emit(a)
+}
+
+func newAlert(r *rule, key string, now time.Time) *Alert {
+	_, seriesLabels, _ := series.Parse(key)
+	labels := map[string]string{}
+	for k, v := range seriesLabels {
+		labels[k] = v
+	}
+	for k, v := range r.cfg.Labels {
+		labels[k] = v
+	}
+	labels["alertname"] = r.cfg.Name
+	labels["severity"] = r.cfg.Severity
+	return &Alert{
+		ID:       newID(),
+		Rule:     r.cfg.Name,
+		Series:   key,
+		State:    StatePending,
+		Severity: r.cfg.Severity,
+		Labels:   labels,
+		ActiveAt: now,
+	}
+}
+
+// annotate renders the rule's annotation templates for a. Templates see
+// .Value, .Labels, .Series and .Rule.
+func (r *rule) annotate(a *Alert) {
+	if len(r.annotations) == 0 {
+		return
+	}
+	data := map[string]any{"Value": a.Value, "Labels": a.Labels, "Series": a.Series, "Rule": a.Rule}
+	out := make(map[string]string, len(r.annotations))
+	for k, t := range r.annotations {
+		var b strings.Builder
+		if err := t.Execute(&b, data); err != nil {
+			out[k] = r.cfg.Annotations[k]
+			continue
+		}
+		out[k] = b.String()
+	}
+	a.Annotations = out
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Baselines returns the learned baselines of anomaly rules, optionally
+// filtered by rule and series. Recent band points are included when
+// withPoints is set.
+func (e *Engine) Baselines(rule, key string, withPoints bool) []Baseline {
+	out := []Baseline{}
+	for _, r := range e.rules {
+		a, ok := r.cond.(*anomaly)
+		if !ok || (rule != "" && r.cfg.Name != rule) {
+			continue
+		}
+		for _, b := range a.baselines(r.cfg.Name, withPoints) {
+			if key == "" || b.Series == key {
+				out = append(out, b)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Rule != out[j].Rule {
+			return out[i].Rule < out[j].Rule
+		}
+		return out[i].Series < out[j].Series
+	})
+	return out
+}
+
+var stateOrder = map[State]int{StateFiring: 0, StatePending: 1, StateResolved: 2}
+
+// Alerts returns the active alerts and recently resolved ones, firing
+// first and newest first within each state.
+func (e *Engine) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]Alert, 0, len(e.active)+len(e.resolved))
+	for _, a := range e.active {
+		out = append(out, *a)
+	}
+	for _, a := range e.resolved {
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].State != out[j].State {
+			return stateOrder[out[i].State] < stateOrder[out[j].State]
+		}
+		return out[i].ActiveAt.After(out[j].ActiveAt)
+	})
+	return out
+}
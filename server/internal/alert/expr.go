@@ -0,0 +1,266 @@
+package alert
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/forecast"
+	"github.com/gofyr/server_monitor/server/internal/series"
+	"github.com/gofyr/server_monitor/server/internal/tsdb"
+)
+
+// A condition evaluates a rule against the latest series values. It returns
+// every series the rule applies to, flagging the ones that currently match.
+type condition interface {
+	eval(now time.Time, values map[string]float64) map[string]result
+}
+
+type result struct {
+	value  float64
+	active bool
+}
+
+// selector picks series by name and labels. disk_usage["/"] is shorthand for
+// the disk_usage series whose only label has the value "/".
+type selector struct {
+	name     string
+	short    *string
+	matchers map[string]string
+}
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*`)
+
+func parseSelector(s string) (selector, error) {
+	s = strings.TrimSpace(s)
+	name := namePattern.FindString(s)
+	if name == "" {
+		return selector{}, fmt.Errorf("expected metric name in %q", s)
+	}
+	sel := selector{name: name}
+	rest := strings.TrimSpace(s[len(name):])
+	switch {
+	case rest == "":
+	case strings.HasPrefix(rest, "[") && strings.HasSuffix(rest, "]"):
+		v, err := strconv.Unquote(strings.TrimSpace(rest[1 : len(rest)-1]))
+		if err != nil {
+			return selector{}, fmt.Errorf("bad label value in %q", s)
+		}
+		sel.short = &v
+	case strings.HasPrefix(rest, "{"):
+		_, labels, err := series.Parse(name + rest)
+		if err != nil {
+			return selector{}, fmt.Errorf("bad label matchers in %q: %v", s, err)
+		}
+		sel.matchers = labels
+	default:
+		return selector{}, fmt.Errorf("unexpected %q after metric name", rest)
+	}
+	return sel, nil
+}
+
+func (s selector) matches(key string) bool {
+	if series.Name(key) != s.name {
+		return false
+	}
+	if s.short == nil && len(s.matchers) == 0 {
+		return true
+	}
+	_, labels, err := series.Parse(key)
+	if err != nil {
+		return false
+	}
+	if s.short != nil {
+		if len(labels) != 1 {
+			return false
+		}
+		for _, v := range labels {
+			return v == *s.short
+		}
+	}
+	for k, v := range s.matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s selector) selectFrom(values map[string]float64) map[string]float64 {
+	out := map[string]float64{}
+	for key, v := range values {
+		if s.matches(key) {
+			out[key] = v
+		}
+	}
+	return out
+}
+
+// threshold compares series, or a value derived from them, against a
+// constant.
+type threshold struct {
+	sel    selector
+	op     string
+	value  float64
+	source derived
+}
+
+// derived maps a series to the value a rule compares instead of the series
+// itself. It reports false when there is no value.
+type derived interface {
+	value(now time.Time, key string, v float64) (float64, bool)
+}
+
+func (t *threshold) eval(now time.Time, values map[string]float64) map[string]result {
+	out := map[string]result{}
+	for key, v := range t.sel.selectFrom(values) {
+		if t.source != nil {
+			var ok bool
+			if v, ok = t.source.value(now, key, v); !ok {
+				continue
+			}
+		}
+		out[key] = result{value: v, active: compare(v, t.op, t.value)}
+	}
+	return out
+}
+
+// timeToFull derives the seconds until a series' trend reaches limit.
+// Series that are not rising have no value.
+type timeToFull struct {
+	sel    selector
+	window time.Duration
+	limit  float64
+	fc     *forecast.Forecaster
+}
+
+func parseTimeToFull(args string, fc *forecast.Forecaster) (*timeToFull, error) {
+	parts := splitArgs(args)
+	sel, err := parseSelector(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	c := &timeToFull{sel: sel, window: forecast.DefaultWindow, limit: 100, fc: fc}
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if d, err := time.ParseDuration(p); err == nil && d > 0 {
+			c.window = d
+		} else if f, err := strconv.ParseFloat(p, 64); err == nil {
+			c.limit = f
+		} else {
+			return nil, fmt.Errorf("time_to_full: unknown argument %q", p)
+		}
+	}
+	if fc == nil {
+		return nil, fmt.Errorf("time_to_full: no metric history available")
+	}
+	return c, nil
+}
+
+func (c *timeToFull) value(now time.Time, key string, _ float64) (float64, bool) {
+	trend, ok := c.fc.Trend(key, c.window, now)
+	if !ok {
+		return 0, false
+	}
+	d, ok := trend.TimeTo(c.limit)
+	return d.Seconds(), ok
+}
+
+func compare(a float64, op string, b float64) bool {
+	switch op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
+
+var forSuffix = regexp.MustCompile(`^(.*\S)\s+for\s+(\S+)$`)
+
+// Operators in match order, longest first.
+var operators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// parseExpr parses "<selector> <op> <number> [for <duration>]", where the
+// left side may also be anomaly(<selector>[, seasonal][, <tau>]) to compare
+// the z-score against a learned baseline, or time_to_full(<selector>[,
+// <window>][, <limit>]) to compare the seconds until the series' trend
+// reaches limit.
+func parseExpr(expr string, db *tsdb.DB, fc *forecast.Forecaster) (condition, time.Duration, error) {
+	expr = strings.TrimSpace(expr)
+	var wait time.Duration
+	if m := forSuffix.FindStringSubmatch(expr); m != nil {
+		d, err := time.ParseDuration(m[2])
+		if err != nil {
+			return nil, 0, fmt.Errorf("bad duration %q", m[2])
+		}
+		expr, wait = m[1], d
+	}
+	i, op := findOperator(expr)
+	if i < 0 {
+		return nil, 0, fmt.Errorf("no comparison operator in %q", expr)
+	}
+	rhs := strings.TrimSpace(expr[i+len(op):])
+	v, err := strconv.ParseFloat(rhs, 64)
+	if err != nil {
+		// Durations compare as seconds, e.g. time_to_full(...) < 48h
+		d, derr := time.ParseDuration(rhs)
+		if derr != nil {
+			return nil, 0, fmt.Errorf("bad threshold %q", rhs)
+		}
+		v = d.Seconds()
+	}
+	lhs := strings.TrimSpace(expr[:i])
+	if strings.HasPrefix(lhs, "time_to_full(") && strings.HasSuffix(lhs, ")") {
+		c, err := parseTimeToFull(lhs[len("time_to_full("):len(lhs)-1], fc)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &threshold{sel: c.sel, op: op, value: v, source: c}, wait, nil
+	}
+	if strings.HasPrefix(lhs, "anomaly(") && strings.HasSuffix(lhs, ")") {
+		a, err := parseAnomaly(lhs[len("anomaly("):len(lhs)-1], db)
+		if err != nil {
+			return nil, 0, err
+		}
+		a.op, a.threshold, a.sigma = op, v, math.Abs(v)
+		return a, wait, nil
+	}
+	sel, err := parseSelector(lhs)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &threshold{sel: sel, op: op, value: v}, wait, nil
+}
+
+// findOperator returns the position of the first comparison operator
+// outside quotes.
+func findOperator(s string) (int, string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted:
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					return i, op
+				}
+			}
+		}
+	}
+	return -1, ""
+}
@@ -0,0 +1,164 @@
+package alert
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The history file is rewritten once this many changes were appended to
+// it, or at the latest after compactInterval.
+const (
+	compactLines    = 1000
+	compactInterval = 24 * time.Hour
+)
+
+// History records every alert that fired, with its start, end and
+// acknowledgement times. Each change is appended to a JSON-lines file; the
+// file is rewritten with one line per alert when opened and periodically
+// while running, dropping alerts past the retention.
+type History struct {
+	path      string
+	retention time.Duration
+
+	mu          sync.Mutex
+	f           *os.File
+	alerts      map[string]*Alert
+	appended    int
+	compactedAt time.Time
+}
+
+func OpenHistory(path string, retention time.Duration) (*History, error) {
+	h := &History{path: path, retention: retention, alerts: map[string]*Alert{}}
+	if f, err := os.Open(path); err == nil {
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64<<10), 1<<20)
+		for sc.Scan() {
+			var a Alert
+			// A torn last line from a crash is skipped
+			if json.Unmarshal(sc.Bytes(), &a) == nil && a.ID != "" {
+				h.alerts[a.ID] = &a
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := h.compact(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// compact drops alerts that ended before the retention, rewrites the file
+// and reopens it for appending.
+func (h *History) compact() error {
+	if h.f != nil {
+		h.f.Close()
+		h.f = nil
+	}
+	cutoff := time.Now().Add(-h.retention)
+	tmp := h.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for id, a := range h.alerts {
+		if a.ResolvedAt != nil && a.ResolvedAt.Before(cutoff) {
+			delete(h.alerts, id)
+			continue
+		}
+		enc.Encode(a)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+	f, err = os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	h.f = f
+	h.appended = 0
+	h.compactedAt = time.Now()
+	return nil
+}
+
+// Record stores the alert of a firing, resolved or acknowledged event.
+// Alerts that have not fired, including acknowledged pending ones, are not
+// recorded. It is meant to be registered with Engine.AddListener.
+func (h *History) Record(ev Event) {
+	a := ev.Alert
+	if a.State == StatePending {
+		return
+	}
+	b, err := json.Marshal(&a)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.alerts[a.ID] = &a
+	if h.f == nil || h.appended >= compactLines || time.Since(h.compactedAt) >= compactInterval {
+		// The rewritten file already holds a
+		if err := h.compact(); err != nil {
+			log.Printf("alert history: compacting: %v", err)
+		}
+		return
+	}
+	if _, err := h.f.Write(append(b, '\n')); err != nil {
+		log.Printf("alert history: %v", err)
+	}
+	h.appended++
+}
+
+// Query returns the alerts active at any time between from and to, newest
+// first.
+func (h *History) Query(from, to time.Time) []Alert {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := []Alert{}
+	for _, a := range h.alerts {
+		if a.ActiveAt.After(to) || (a.ResolvedAt != nil && a.ResolvedAt.Before(from)) {
+			continue
+		}
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ActiveAt.After(out[j].ActiveAt) })
+	return out
+}
+
+// Unresolved returns the alerts that were still firing when the history
+// was last written.
+func (h *History) Unresolved() []Alert {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []Alert
+	for _, a := range h.alerts {
+		if a.State == StateFiring {
+			out = append(out, *a)
+		}
+	}
+	return out
+}
+
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.f == nil {
+		return nil
+	}
+	return h.f.Close()
+}
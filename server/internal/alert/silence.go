@@ -0,0 +1,308 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/config"
+)
+
+// Expired silences are kept this long for reference.
+const expiredRetention = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidSilence  = errors.New("invalid silence")
+	ErrSilenceNotFound = errors.New("silence not found")
+)
+
+// Matcher matches an alert label by value or, with Regex, by a fully
+// anchored regular expression.
+type Matcher struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Regex bool   `json:"regex,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func (m *Matcher) compile() error {
+	if m.Name == "" {
+		return errors.New("matcher name required")
+	}
+	if m.Regex {
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return fmt.Errorf("matcher %q: %w", m.Name, err)
+		}
+		m.re = re
+	}
+	return nil
+}
+
+func (m *Matcher) matches(labels map[string]string) bool {
+	if m.re != nil {
+		return m.re.MatchString(labels[m.Name])
+	}
+	return labels[m.Name] == m.Value
+}
+
+func matchAll(ms []Matcher, labels map[string]string) bool {
+	for i := range ms {
+		if !ms[i].matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Silence mutes notifications for matching alerts between StartsAt and
+// EndsAt.
+type Silence struct {
+	ID        string    `json:"id"`
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	Comment   string    `json:"comment"`
+	// pending, active or expired; computed when listed
+	Status string `json:"status"`
+}
+
+func (s *Silence) status(now time.Time) string {
+	switch {
+	case now.Before(s.StartsAt):
+		return "pending"
+	case now.Before(s.EndsAt):
+		return "active"
+	}
+	return "expired"
+}
+
+type window struct {
+	cfg      config.MaintenanceWindow
+	days     map[time.Weekday]bool
+	start    time.Duration // offsets into the day
+	end      time.Duration
+	loc      *time.Location
+	matchers []Matcher
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+func newWindow(cfg config.MaintenanceWindow) (*window, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("maintenance window name required")
+	}
+	w := &window{cfg: cfg, days: map[time.Weekday]bool{}, loc: time.Local}
+	for _, d := range cfg.Days {
+		wd, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return nil, fmt.Errorf("maintenance window %q: unknown day %q", cfg.Name, d)
+		}
+		w.days[wd] = true
+	}
+	if len(w.days) == 0 {
+		for _, wd := range weekdays {
+			w.days[wd] = true
+		}
+	}
+	var err error
+	if w.start, err = parseClock(cfg.Start); err != nil {
+		return nil, fmt.Errorf("maintenance window %q: start: %w", cfg.Name, err)
+	}
+	if w.end, err = parseClock(cfg.End); err != nil {
+		return nil, fmt.Errorf("maintenance window %q: end: %w", cfg.Name, err)
+	}
+	if cfg.Timezone != "" {
+		if w.loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("maintenance window %q: %w", cfg.Name, err)
+		}
+	}
+	for k, v := range cfg.Matchers {
+		w.matchers = append(w.matchers, Matcher{Name: k, Value: v})
+	}
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("want HH:MM, got %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// active reports whether t falls inside the window. A window whose end is
+// before its start runs past midnight into the next day.
+func (w *window) active(t time.Time) bool {
+	t = t.In(w.loc)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.start <= w.end {
+		return w.days[t.Weekday()] && offset >= w.start && offset < w.end
+	}
+	if offset >= w.start {
+		return w.days[t.Weekday()]
+	}
+	return offset < w.end && w.days[(t.Weekday()+6)%7]
+}
+
+// Silences holds the silences created through the API, saved as JSON at
+// path, and the maintenance windows from the config.
+type Silences struct {
+	path    string
+	windows []*window
+
+	mu       sync.Mutex
+	silences []*Silence
+}
+
+func OpenSilences(path string, windows []config.MaintenanceWindow) (*Silences, error) {
+	s := &Silences{path: path}
+	for _, wc := range windows {
+		w, err := newWindow(wc)
+		if err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &s.silences); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for _, sil := range s.silences {
+		for i := range sil.Matchers {
+			if err := sil.Matchers[i].compile(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// Add validates and stores a new silence. Validation errors wrap
+// ErrInvalidSilence; any other error means the silences could not be saved.
+func (s *Silences) Add(sil Silence) (Silence, error) {
+	if len(sil.Matchers) == 0 {
+		return Silence{}, fmt.Errorf("%w: at least one matcher required", ErrInvalidSilence)
+	}
+	for i := range sil.Matchers {
+		if err := sil.Matchers[i].compile(); err != nil {
+			return Silence{}, fmt.Errorf("%w: %v", ErrInvalidSilence, err)
+		}
+	}
+	now := time.Now()
+	if sil.StartsAt.IsZero() {
+		sil.StartsAt = now
+	}
+	if !sil.EndsAt.After(sil.StartsAt) || !sil.EndsAt.After(now) {
+		return Silence{}, fmt.Errorf("%w: ends_at must be in the future and after starts_at", ErrInvalidSilence)
+	}
+	sil.ID = newID()
+	sil.CreatedAt = now
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.silences
+	s.silences = append(s.silences[:len(prev):len(prev)], &sil)
+	if err := s.save(now); err != nil {
+		s.silences = prev
+		return Silence{}, err
+	}
+	sil.Status = sil.status(now)
+	return sil, nil
+}
+
+// List returns all silences, newest first.
+func (s *Silences) List() []Silence {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Silence, 0, len(s.silences))
+	for _, sil := range s.silences {
+		c := *sil
+		c.Status = c.status(now)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// Delete removes a silence, returning ErrSilenceNotFound if there is none
+// with that ID.
+func (s *Silences) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sil := range s.silences {
+		if sil.ID == id {
+			prev := s.silences
+			s.silences = append(prev[:i:i], prev[i+1:]...)
+			if err := s.save(time.Now()); err != nil {
+				s.silences = prev
+				return err
+			}
+			return nil
+		}
+	}
+	return ErrSilenceNotFound
+}
+
+// silencedBy returns the IDs of active silences and the names of active
+// maintenance windows, as "maintenance:<name>", that match labels.
+func (s *Silences) silencedBy(labels map[string]string, now time.Time) []string {
+	if s == nil {
+		return nil
+	}
+	var out []string
+	s.mu.Lock()
+	for _, sil := range s.silences {
+		if sil.status(now) == "active" && matchAll(sil.Matchers, labels) {
+			out = append(out, sil.ID)
+		}
+	}
+	s.mu.Unlock()
+	for _, w := range s.windows {
+		if w.active(now) && matchAll(w.matchers, labels) {
+			out = append(out, "maintenance:"+w.cfg.Name)
+		}
+	}
+	return out
+}
+
+// save writes the silences to disk, dropping long-expired ones. Callers
+// hold s.mu.
+func (s *Silences) save(now time.Time) error {
+	var kept []*Silence
+	for _, sil := range s.silences {
+		if now.Sub(sil.EndsAt) < expiredRetention {
+			kept = append(kept, sil)
+		}
+	}
+	b, err := json.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.silences = kept
+	return nil
+}
@@ -23,16 +23,23 @@ func NewJWTManager(cfg *config.Config) (*JWTManager, error) {
 
 type Claims struct {
 	Username string `json:"username"`
+	Role     string `json:"role"`
 	TokenUse string `json:"token_use"`
+	// Server-side session; the refresh token's ID is the registered jti
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func (m *JWTManager) Sign(username string, tokenUse string, ttl time.Duration) (string, error) {
+// Sign issues a token with the identity fields of c, valid for ttl.
+func (m *JWTManager) Sign(c Claims, ttl time.Duration) (string, error) {
 	now := time.Now()
 	claims := &Claims{
-		Username: username,
-		TokenUse: tokenUse,
+		Username:  c.Username,
+		Role:      c.Role,
+		TokenUse:  c.TokenUse,
+		SessionID: c.SessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        c.ID,
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		},
@@ -55,11 +62,16 @@ func (m *JWTManager) Verify(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
-func (m *JWTManager) IssuePair(username string) (access string, refresh string, err error) {
-	access, err = m.Sign(username, "access", m.accessTTL)
+// IssuePair issues an access and a refresh token for a session. refreshID
+// becomes the refresh token's jti.
+func (m *JWTManager) IssuePair(username, role, sessionID, refreshID string) (access string, refresh string, err error) {
+	c := Claims{Username: username, Role: role, TokenUse: "access", SessionID: sessionID}
+	access, err = m.Sign(c, m.accessTTL)
 	if err != nil {
 		return
 	}
-	refresh, err = m.Sign(username, "refresh", m.refreshTTL)
+	c.TokenUse = "refresh"
+	c.ID = refreshID
+	refresh, err = m.Sign(c, m.refreshTTL)
 	return
 }
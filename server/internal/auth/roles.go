@@ -0,0 +1,22 @@
+package auth
+
+// Roles in increasing order of privilege. Viewers can read everything,
+// operators can also act (acknowledge, silence, dump, ...) and admins can
+// manage users and credentials.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// Allows reports whether role grants at least the privileges of min.
+// Unknown roles grant nothing.
+func Allows(role, min string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[min]
+}
@@ -0,0 +1,207 @@
+// Package checks runs Nagios-compatible check plugins declared in the config
+// and exposes their state and performance data.
+package checks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/collector"
+	"github.com/gofyr/server_monitor/server/internal/config"
+	"github.com/gofyr/server_monitor/server/internal/series"
+)
+
+// Plugin exit codes
+const (
+	OK       = 0
+	Warning  = 1
+	Critical = 2
+	Unknown  = 3
+)
+
+// After a timeout, output pipes are closed this long after the kill.
+const killWait = 2 * time.Second
+
+// Plugins may print at most this much; the rest is discarded.
+const maxOutput = 8 << 10
+
+var stateNames = [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+type Result struct {
+	Name       string    `json:"name"`
+	Status     int       `json:"status"`
+	State      string    `json:"state"`
+	Output     string    `json:"output"`
+	LongOutput string    `json:"long_output,omitempty"`
+	Perfdata   []Perf    `json:"perfdata"`
+	CheckedAt  time.Time `json:"checked_at"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+func collectorName(check string) string { return "check:" + check }
+
+// Runner owns the configured checks. Each check runs as its own collector
+// in the registry, so it gets the registry's scheduling and timeouts.
+type Runner struct {
+	reg    *collector.Registry
+	checks []config.CheckConfig
+}
+
+func NewRunner(reg *collector.Registry, checks []config.CheckConfig) (*Runner, error) {
+	seen := map[string]bool{}
+	for _, c := range checks {
+		if c.Name == "" || c.Command == "" {
+			return nil, errors.New("checks: name and command are required")
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("checks: duplicate check %q", c.Name)
+		}
+		seen[c.Name] = true
+	}
+	r := &Runner{reg: reg, checks: checks}
+	for _, c := range checks {
+		interval := c.Interval
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		timeout := c.Timeout
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		reg.Register(&plugin{cfg: c}, collector.Options{Enabled: true, Interval: interval, Timeout: timeout})
+	}
+	return r, nil
+}
+
+// Results returns the latest result of every check, sorted by name. A check
+// whose run failed, for example by timing out, is reported as UNKNOWN.
+func (r *Runner) Results() []Result {
+	out := make([]Result, 0, len(r.checks))
+	for _, c := range r.checks {
+		v, at, err := r.reg.Value(collectorName(c.Name))
+		switch {
+		case err == collector.ErrPending:
+			continue
+		case err != nil:
+			out = append(out, Result{Name: c.Name, Status: Unknown, State: stateNames[Unknown], Output: err.Error(), Perfdata: []Perf{}, CheckedAt: at})
+		default:
+			out = append(out, v.(Result))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Series exposes each check's status and perfdata values as
+// check_status{check} and check_perfdata{check,label}.
+func (r *Runner) Series() map[string]float64 {
+	out := map[string]float64{}
+	for _, res := range r.Results() {
+		out[series.Key("check_status", map[string]string{"check": res.Name})] = float64(res.Status)
+		for _, p := range res.Perfdata {
+			out[series.Key("check_perfdata", map[string]string{"check": res.Name, "label": p.Label})] = p.Value
+		}
+	}
+	return out
+}
+
+// OwnedNames reserves the check series names for the runner.
+func (r *Runner) OwnedNames() []string {
+	return []string{"check_status", "check_perfdata"}
+}
+
+type plugin struct {
+	cfg config.CheckConfig
+}
+
+func (p *plugin) Name() string { return collectorName(p.cfg.Name) }
+
+func (p *plugin) Collect(ctx context.Context) (any, error) {
+	cmd := exec.CommandContext(ctx, p.cfg.Command, p.cfg.Args...)
+	// The plugin gets its own process group so a timeout also kills the
+	// children it started, and Run returns even if one of them still holds
+	// stdout.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = killWait
+	if p.cfg.User != "" {
+		cred, err := lookupCredential(p.cfg.User)
+		if err != nil {
+			return nil, err
+		}
+		cmd.SysProcAttr.Credential = cred
+	}
+	var stdout limitedBuffer
+	cmd.Stdout = &stdout
+	start := time.Now()
+	err := cmd.Run()
+	res := Result{Name: p.cfg.Name, CheckedAt: start, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		res.Status = OK
+	case errors.As(err, &exitErr) && exitErr.Exited():
+		res.Status = exitErr.ExitCode()
+	default:
+		// Could not start or was killed by a signal
+		res.Status = Unknown
+		res.Output = err.Error()
+	}
+	if res.Status < OK || res.Status > Unknown {
+		res.Status = Unknown
+	}
+	res.State = stateNames[res.Status]
+	status, long, perf := parseOutput(stdout.String())
+	if status != "" {
+		res.Output = status
+	}
+	res.LongOutput = long
+	res.Perfdata = perf
+	if res.Perfdata == nil {
+		res.Perfdata = []Perf{}
+	}
+	return res, nil
+}
+
+func lookupCredential(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
+
+// limitedBuffer keeps the first maxOutput bytes written to it.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxOutput - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
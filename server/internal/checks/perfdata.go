@@ -0,0 +1,135 @@
+package checks
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Perf is one performance data item: 'label'=value[UOM];[warn];[crit];[min];[max]
+type Perf struct {
+	Label string   `json:"label"`
+	Value float64  `json:"value"`
+	UOM   string   `json:"uom,omitempty"`
+	Warn  string   `json:"warn,omitempty"`
+	Crit  string   `json:"crit,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+// parseOutput splits plugin output into the status line, long output and
+// performance data following the Nagios plugin guidelines: perfdata follows
+// a '|' on the first line and on the first long-output line containing one,
+// after which every remaining line is perfdata.
+func parseOutput(out string) (status, long string, perf []Perf) {
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) == 0 {
+		return "", "", nil
+	}
+	var perfText []string
+	first := lines[0]
+	if i := strings.IndexByte(first, '|'); i >= 0 {
+		perfText = append(perfText, first[i+1:])
+		first = first[:i]
+	}
+	status = strings.TrimSpace(first)
+	var longLines []string
+	inPerf := false
+	for _, ln := range lines[1:] {
+		if inPerf {
+			perfText = append(perfText, ln)
+			continue
+		}
+		if i := strings.IndexByte(ln, '|'); i >= 0 {
+			longLines = append(longLines, ln[:i])
+			perfText = append(perfText, ln[i+1:])
+			inPerf = true
+			continue
+		}
+		longLines = append(longLines, ln)
+	}
+	long = strings.TrimSpace(strings.Join(longLines, "\n"))
+	perf = parsePerfdata(strings.Join(perfText, " "))
+	return status, long, perf
+}
+
+// parsePerfdata parses space separated items, skipping malformed ones.
+func parsePerfdata(s string) []Perf {
+	var out []Perf
+	for {
+		s = strings.TrimLeft(s, " \t\n")
+		if s == "" {
+			return out
+		}
+		var label string
+		if s[0] == '\'' {
+			// Quoted label; '' is an escaped quote
+			var b strings.Builder
+			i := 1
+			for i < len(s) {
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						b.WriteByte('\'')
+						i += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			label = b.String()
+			s = s[min(i+1, len(s)):]
+		} else {
+			i := strings.IndexByte(s, '=')
+			if i < 0 {
+				return out
+			}
+			label = s[:i]
+			s = s[i:]
+		}
+		end := strings.IndexAny(s, " \t\n")
+		if end < 0 {
+			end = len(s)
+		}
+		item := s[:end]
+		s = s[end:]
+		if !strings.HasPrefix(item, "=") {
+			continue
+		}
+		if p, ok := parsePerfItem(label, item[1:]); ok {
+			out = append(out, p)
+		}
+	}
+}
+
+func parsePerfItem(label, v string) (Perf, bool) {
+	fields := strings.Split(v, ";")
+	num := fields[0]
+	i := 0
+	for i < len(num) && (num[i] >= '0' && num[i] <= '9' || num[i] == '.' || num[i] == '-' || num[i] == '+' || num[i] == 'e' || num[i] == 'E') {
+		i++
+	}
+	// 'U' marks an undeterminable value
+	val, err := strconv.ParseFloat(num[:i], 64)
+	if err != nil {
+		return Perf{}, false
+	}
+	p := Perf{Label: label, Value: val, UOM: num[i:]}
+	if len(fields) > 1 {
+		p.Warn = fields[1]
+	}
+	if len(fields) > 2 {
+		p.Crit = fields[2]
+	}
+	if len(fields) > 3 {
+		if f, err := strconv.ParseFloat(fields[3], 64); err == nil {
+			p.Min = &f
+		}
+	}
+	if len(fields) > 4 {
+		if f, err := strconv.ParseFloat(fields[4], 64); err == nil {
+			p.Max = &f
+		}
+	}
+	return p, true
+}
@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+type Process struct {
+	PID      int32   `json:"pid"`
+	Name     string  `json:"name"`
+	CPU      float64 `json:"cpu"`
+	Memory   uint64  `json:"memory"`
+	Username string  `json:"username"`
+}
+
+// ListProcesses reads every process on the host. Processes that exit while
+// being read are skipped.
+func ListProcesses(ctx context.Context) ([]Process, error) {
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Process, 0, len(pids))
+	for _, pid := range pids {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		p, err := process.NewProcessWithContext(ctx, pid)
+		if err != nil {
+			continue
+		}
+		name, _ := p.NameWithContext(ctx)
+		cpu, _ := p.CPUPercentWithContext(ctx)
+		memInfo, _ := p.MemoryInfoWithContext(ctx)
+		user, _ := p.UsernameWithContext(ctx)
+		var mem uint64
+		if memInfo != nil {
+			mem = memInfo.RSS
+		}
+		list = append(list, Process{PID: pid, Name: name, CPU: cpu, Memory: mem, Username: user})
+	}
+	return list, nil
+}
+
+// processCollector lists processes with their CPU use since its previous
+// run, in percent of one core. Process handles are kept between runs so
+// their CPU times can be compared; a process's first run reports zero.
+type processCollector struct {
+	procs map[int32]*process.Process
+}
+
+func (c *processCollector) Name() string { return Processes }
+
+func (c *processCollector) Collect(ctx context.Context) (any, error) {
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int32]*process.Process, len(pids))
+	list := make([]Process, 0, len(pids))
+	for _, pid := range pids {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		p := c.procs[pid]
+		if p == nil {
+			if p, err = process.NewProcessWithContext(ctx, pid); err != nil {
+				continue
+			}
+		}
+		cpu, err := p.PercentWithContext(ctx, 0)
+		if err != nil {
+			continue
+		}
+		seen[pid] = p
+		name, _ := p.NameWithContext(ctx)
+		memInfo, _ := p.MemoryInfoWithContext(ctx)
+		user, _ := p.UsernameWithContext(ctx)
+		var mem uint64
+		if memInfo != nil {
+			mem = memInfo.RSS
+		}
+		list = append(list, Process{PID: pid, Name: name, CPU: cpu, Memory: mem, Username: user})
+	}
+	c.procs = seen
+	return list, nil
+}
@@ -0,0 +1,241 @@
+// Package collector runs data sources on their own schedules, isolated from
+// each other and from request handlers, and records how each one fared.
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/config"
+)
+
+// Collector is a single data source. Collect must honour ctx; a collector
+// that ignores it is abandoned after its timeout and not rerun until the
+// stuck call returns.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) (any, error)
+}
+
+var (
+	ErrDisabled = errors.New("collector disabled")
+	ErrPending  = errors.New("collector has not run yet")
+)
+
+type Options struct {
+	Enabled  bool
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// Status reports the health of one collector.
+type Status struct {
+	Name        string     `json:"name"`
+	Enabled     bool       `json:"enabled"`
+	Interval    float64    `json:"interval"`
+	Timeout     float64    `json:"timeout"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	DurationMS  float64    `json:"duration_ms"`
+	Error       string     `json:"error,omitempty"`
+}
+
+type entry struct {
+	c    Collector
+	opts Options
+
+	mu       sync.Mutex
+	value    any
+	err      error
+	at       time.Time
+	lastRun  time.Time
+	lastOK   time.Time
+	duration time.Duration
+	inflight bool
+}
+
+type Registry struct {
+	mu        sync.RWMutex
+	entries   map[string]*entry
+	overrides map[string]config.CollectorConfig
+	started   context.Context
+}
+
+func NewRegistry(cfg *config.Config) *Registry {
+	return &Registry{entries: map[string]*entry{}, overrides: cfg.Collectors}
+}
+
+// Register adds c with def as its default options; settings under
+// collectors.<name> in the config take precedence. Collectors registered
+// after Run has started are scheduled immediately.
+func (r *Registry) Register(c Collector, def Options) {
+	opts := def
+	if o, ok := r.overrides[c.Name()]; ok {
+		if o.Enabled != nil {
+			opts.Enabled = *o.Enabled
+		}
+		if o.Interval > 0 {
+			opts.Interval = o.Interval
+		}
+		if o.Timeout > 0 {
+			opts.Timeout = o.Timeout
+		}
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = opts.Interval
+	}
+	e := &entry{c: c, opts: opts}
+	r.mu.Lock()
+	if _, dup := r.entries[c.Name()]; dup {
+		r.mu.Unlock()
+		log.Printf("collector: duplicate registration of %q ignored", c.Name())
+		return
+	}
+	r.entries[c.Name()] = e
+	ctx := r.started
+	r.mu.Unlock()
+	if ctx != nil && opts.Enabled {
+		go r.loop(ctx, e)
+	}
+}
+
+// Run schedules every enabled collector until ctx is cancelled.
+func (r *Registry) Run(ctx context.Context) {
+	r.mu.Lock()
+	r.started = ctx
+	entries := make([]*entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	r.mu.Unlock()
+	for _, e := range entries {
+		if e.opts.Enabled {
+			go r.loop(ctx, e)
+		}
+	}
+	<-ctx.Done()
+}
+
+func (r *Registry) loop(ctx context.Context, e *entry) {
+	r.runOnce(ctx, e)
+	ticker := time.NewTicker(e.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.runOnce(ctx, e)
+		}
+	}
+}
+
+type result struct {
+	v   any
+	err error
+}
+
+func (r *Registry) runOnce(parent context.Context, e *entry) {
+	e.mu.Lock()
+	if e.inflight {
+		e.err = fmt.Errorf("previous run still in progress after %s", time.Since(e.lastRun).Round(time.Millisecond))
+		e.value = nil
+		e.mu.Unlock()
+		return
+	}
+	e.inflight = true
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(parent, e.opts.Timeout)
+	start := time.Now()
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				done <- result{err: fmt.Errorf("panic: %v", rec)}
+			}
+			e.mu.Lock()
+			e.inflight = false
+			e.mu.Unlock()
+		}()
+		v, err := e.c.Collect(ctx)
+		done <- result{v: v, err: err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.err = fmt.Errorf("timed out after %s", e.opts.Timeout)
+	}
+	cancel()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastRun = start
+	e.duration = time.Since(start)
+	e.err = res.err
+	e.value = nil
+	e.at = start
+	if res.err == nil {
+		e.value = res.v
+		e.lastOK = start
+	}
+}
+
+// Value returns the result of the collector's latest run and when that run
+// started. The value is nil when that run failed, in which case err
+// explains why. Disabled or unknown collectors return ErrDisabled.
+func (r *Registry) Value(name string) (any, time.Time, error) {
+	r.mu.RLock()
+	e, ok := r.entries[name]
+	r.mu.RUnlock()
+	if !ok || !e.opts.Enabled {
+		return nil, time.Time{}, ErrDisabled
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.value == nil && e.err == nil {
+		return nil, time.Time{}, ErrPending
+	}
+	return e.value, e.at, e.err
+}
+
+// Statuses lists every registered collector sorted by name.
+func (r *Registry) Statuses() []Status {
+	r.mu.RLock()
+	out := make([]Status, 0, len(r.entries))
+	for _, e := range r.entries {
+		e.mu.Lock()
+		st := Status{
+			Name:       e.c.Name(),
+			Enabled:    e.opts.Enabled,
+			Interval:   e.opts.Interval.Seconds(),
+			Timeout:    e.opts.Timeout.Seconds(),
+			DurationMS: float64(e.duration.Microseconds()) / 1000,
+		}
+		if !e.lastRun.IsZero() {
+			t := e.lastRun
+			st.LastRun = &t
+		}
+		if !e.lastOK.IsZero() {
+			t := e.lastOK
+			st.LastSuccess = &t
+		}
+		if e.err != nil {
+			st.Error = e.err.Error()
+		}
+		e.mu.Unlock()
+		out = append(out, st)
+	}
+	r.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+type Service struct {
+	Name   string `json:"name"`
+	Active string `json:"active"`
+	Sub    string `json:"sub"`
+}
+
+func collectServices(ctx context.Context) ([]Service, error) {
+	conn, err := dbus.NewWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	units, err := conn.ListUnitsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Service, 0, len(units))
+	for _, u := range units {
+		if strings.HasSuffix(u.Name, ".service") {
+			out = append(out, Service{Name: u.Name, Active: u.ActiveState, Sub: u.SubState})
+		}
+	}
+	return out, nil
+}
+
+type Container struct {
+	ID    string `json:"id"`
+	Image string `json:"image"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// collectContainers asks docker, then podman. A host with neither installed
+// simply has no containers; a runtime that is present but fails is an error.
+func collectContainers(ctx context.Context) ([]Container, error) {
+	var lastErr error
+	for _, bin := range []string{"docker", "podman"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			continue
+		}
+		cmd := exec.CommandContext(ctx, bin, "ps", "--format", "{{.ID}}\t{{.Image}}\t{{.Names}}\t{{.Status}}")
+		b, err := cmd.Output()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		out := make([]Container, 0, len(lines))
+		for _, ln := range lines {
+			if ln == "" {
+				continue
+			}
+			parts := strings.Split(ln, "\t")
+			if len(parts) >= 4 {
+				out = append(out, Container{ID: parts[0], Image: parts[1], Name: parts[2], State: parts[3]})
+			}
+		}
+		return out, nil
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return []Container{}, nil
+}
@@ -0,0 +1,172 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// Names of the built-in collectors, as used under collectors: in the config.
+const (
+	CPU        = "cpu"
+	Load       = "load"
+	Memory     = "memory"
+	Disk       = "disk"
+	DiskIO     = "diskio"
+	Network    = "network"
+	Host       = "host"
+	Services   = "services"
+	Containers = "containers"
+	Processes  = "processes"
+)
+
+// RegisterBuiltin registers the built-in host collectors. Those feeding the
+// metrics snapshot default to the sampling interval. The process list is
+// only collected when withProcesses is set, as it scans all of /proc.
+func RegisterBuiltin(r *Registry, sampleInterval time.Duration, withProcesses bool) {
+	fast := Options{Enabled: true, Interval: sampleInterval, Timeout: 5 * time.Second}
+	r.Register(Func(CPU, collectCPU), fast)
+	r.Register(Func(Load, collectLoad), fast)
+	r.Register(Func(Memory, collectMemory), fast)
+	r.Register(Func(Disk, collectMounts), fast)
+	r.Register(Func(DiskIO, collectDiskIO), fast)
+	r.Register(Func(Network, collectNetwork), fast)
+	r.Register(Func(Host, collectHost), Options{Enabled: true, Interval: time.Minute, Timeout: 5 * time.Second})
+	r.Register(Func(Services, collectServices), Options{Enabled: true, Interval: 10 * time.Second, Timeout: 3 * time.Second})
+	r.Register(Func(Containers, collectContainers), Options{Enabled: true, Interval: 10 * time.Second, Timeout: 2 * time.Second})
+	if withProcesses {
+		r.Register(&processCollector{}, Options{Enabled: true, Interval: 15 * time.Second, Timeout: 10 * time.Second})
+	}
+}
+
+type funcCollector struct {
+	name string
+	fn   func(context.Context) (any, error)
+}
+
+// Func adapts a plain function to the Collector interface.
+func Func[T any](name string, fn func(context.Context) (T, error)) Collector {
+	return funcCollector{name: name, fn: func(ctx context.Context) (any, error) {
+		v, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}}
+}
+
+func (f funcCollector) Name() string                             { return f.name }
+func (f funcCollector) Collect(ctx context.Context) (any, error) { return f.fn(ctx) }
+
+type CPUStat struct {
+	Total  float64
+	PerCPU []float64
+}
+
+// collectCPU reports usage since its previous run.
+func collectCPU(ctx context.Context) (CPUStat, error) {
+	total, err := cpu.PercentWithContext(ctx, 0, false)
+	if err != nil {
+		return CPUStat{}, err
+	}
+	per, err := cpu.PercentWithContext(ctx, 0, true)
+	if err != nil {
+		return CPUStat{}, err
+	}
+	st := CPUStat{PerCPU: per}
+	if len(total) > 0 {
+		st.Total = total[0]
+	}
+	return st, nil
+}
+
+func collectLoad(ctx context.Context) (*load.AvgStat, error) {
+	return load.AvgWithContext(ctx)
+}
+
+type MemoryStat struct {
+	Virtual mem.VirtualMemoryStat
+	Swap    mem.SwapMemoryStat
+}
+
+func collectMemory(ctx context.Context) (MemoryStat, error) {
+	vm, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return MemoryStat{}, err
+	}
+	st := MemoryStat{Virtual: *vm}
+	// Hosts without swap still report zeros here; only a read failure is an error
+	sm, err := mem.SwapMemoryWithContext(ctx)
+	if err != nil {
+		return MemoryStat{}, err
+	}
+	st.Swap = *sm
+	return st, nil
+}
+
+type Mount struct {
+	Device     string
+	Mountpoint string
+	Fstype     string
+	Total      uint64
+	Used       uint64
+	Free       uint64
+	UsedPct    float64
+}
+
+// collectMounts skips mounts whose usage cannot be read rather than
+// failing the whole collection.
+func collectMounts(ctx context.Context) ([]Mount, error) {
+	parts, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Mount, 0, len(parts))
+	for _, p := range parts {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		u, err := disk.UsageWithContext(ctx, p.Mountpoint)
+		if err != nil {
+			continue
+		}
+		out = append(out, Mount{
+			Device:     p.Device,
+			Mountpoint: p.Mountpoint,
+			Fstype:     p.Fstype,
+			Total:      u.Total,
+			Used:       u.Used,
+			Free:       u.Free,
+			UsedPct:    u.UsedPercent,
+		})
+	}
+	return out, nil
+}
+
+func collectDiskIO(ctx context.Context) (map[string]disk.IOCountersStat, error) {
+	return disk.IOCountersWithContext(ctx)
+}
+
+func collectNetwork(ctx context.Context) ([]net.IOCountersStat, error) {
+	return net.IOCountersWithContext(ctx, true)
+}
+
+type HostStat struct {
+	BootTime uint64
+}
+
+// collectHost only reads the boot time; uptime is derived from it at
+// sampling time so it stays current between runs.
+func collectHost(ctx context.Context) (HostStat, error) {
+	bt, err := host.BootTimeWithContext(ctx)
+	if err != nil {
+		return HostStat{}, err
+	}
+	return HostStat{BootTime: bt}, nil
+}
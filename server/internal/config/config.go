@@ -40,10 +40,287 @@ type Config struct {
 	ClientKey     string `yaml:"client_key"`
 	ClientKeyHash string `yaml:"client_key_hash"`
 
+	// How often the background sampler reads host metrics
+	SampleInterval time.Duration `yaml:"sample_interval"`
+	// How far back the in-memory metrics history reaches
+	HistoryRetention time.Duration `yaml:"history_retention"`
+
+	// Persistent time-series store under DataDir
+	Storage StorageConfig `yaml:"storage"`
+
+	// Per-collector overrides keyed by collector name
+	Collectors map[string]CollectorConfig `yaml:"collectors"`
+
+	// Nagios-compatible check plugins
+	Checks []CheckConfig `yaml:"checks"`
+
+	// Application metrics pushed via /api/ingest or StatsD
+	Ingest IngestConfig `yaml:"ingest"`
+
+	// Prometheus scrape endpoint at /metrics
+	Prometheus PrometheusConfig `yaml:"prometheus"`
+
+	// Local Prometheus endpoints to scrape
+	Scrape []ScrapeTarget `yaml:"scrape"`
+
+	// OpenTelemetry metrics export
+	OTLP OTLPConfig `yaml:"otlp"`
+
+	// Alert rules evaluated against the sampled metrics
+	Alerts AlertsConfig `yaml:"alerts"`
+
+	// Where alert notifications are delivered
+	Notify NotifyConfig `yaml:"notify"`
+
+	// Dead man's switches pinged by jobs
+	Heartbeats []HeartbeatConfig `yaml:"heartbeats"`
+
+	// High-resolution flight recorder dumped around alerts
+	Recorder RecorderConfig `yaml:"recorder"`
+
 	// Path to loaded config file (not serialized)
 	ConfigFile string `yaml:"-"`
 }
 
+type StorageConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Ordered from finest to coarsest; the first tier must be raw (resolution 0)
+	Tiers []StorageTier `yaml:"tiers"`
+}
+
+type StorageTier struct {
+	Resolution time.Duration `yaml:"resolution"`
+	Retention  time.Duration `yaml:"retention"`
+}
+
+// CollectorConfig overrides a collector's defaults; zero values keep them.
+type CollectorConfig struct {
+	Enabled  *bool         `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+type CheckConfig struct {
+	Name     string        `yaml:"name"`
+	Command  string        `yaml:"command"`
+	Args     []string      `yaml:"args"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// Run as this user instead of the server's; requires privileges to switch
+	User string `yaml:"user"`
+}
+
+type IngestConfig struct {
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	MaxSeries     int           `yaml:"max_series"`
+	StatsD        StatsDConfig  `yaml:"statsd"`
+}
+
+type StatsDConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+}
+
+// PrometheusConfig protects /metrics with a static bearer token, a client
+// certificate signed by client_ca_path, or either of the two.
+type PrometheusConfig struct {
+	Enabled           bool   `yaml:"enabled"`
+	BearerToken       string `yaml:"bearer_token"`
+	BearerTokenHash   string `yaml:"bearer_token_hash"`
+	RequireClientCert bool   `yaml:"require_client_cert"`
+	TopProcesses      int    `yaml:"top_processes"`
+}
+
+type ScrapeTarget struct {
+	Name     string        `yaml:"name"`
+	URL      string        `yaml:"url"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// Regular expressions matched against metric names; only matches are kept
+	Allow       []string `yaml:"allow"`
+	MaxSeries   int      `yaml:"max_series"`
+	BearerToken string   `yaml:"bearer_token"`
+}
+
+type OTLPConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Full URL, e.g. http://collector:4318/v1/metrics
+	Endpoint string `yaml:"endpoint"`
+	// protobuf or json
+	Encoding           string            `yaml:"encoding"`
+	Headers            map[string]string `yaml:"headers"`
+	ResourceAttributes map[string]string `yaml:"resource_attributes"`
+	Interval           time.Duration     `yaml:"interval"`
+	Timeout            time.Duration     `yaml:"timeout"`
+	// Snapshots per request and snapshots kept while the collector is down
+	BatchSize int `yaml:"batch_size"`
+	MaxBuffer int `yaml:"max_buffer"`
+}
+
+type AlertsConfig struct {
+	Rules []AlertRule `yaml:"rules"`
+	// Recurring windows during which notifications are muted
+	Maintenance []MaintenanceWindow `yaml:"maintenance"`
+	// How long resolved alerts are kept in the history
+	HistoryRetention time.Duration `yaml:"history_retention"`
+}
+
+type MaintenanceWindow struct {
+	Name string `yaml:"name"`
+	// Weekday names; every day when empty
+	Days []string `yaml:"days"`
+	// HH:MM; an end before the start runs past midnight
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// IANA zone name (default local time)
+	Timezone string `yaml:"timezone"`
+	// Label values an alert must have; all alerts when empty
+	Matchers map[string]string `yaml:"matchers"`
+}
+
+type AlertRule struct {
+	Name string `yaml:"name"`
+	// e.g. cpu_percent > 90 for 5m or disk_usage["/"] > 85
+	Expr string `yaml:"expr"`
+	// How long the condition must hold before firing, unless given in expr
+	For time.Duration `yaml:"for"`
+	// info, warning or critical (default warning)
+	Severity    string            `yaml:"severity"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type NotifyConfig struct {
+	// Undelivered notifications are dropped after this long
+	MaxAge   time.Duration   `yaml:"max_age"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	Email    []EmailConfig   `yaml:"email"`
+
+	// Mobile push
+	Ntfy        []NtfyConfig        `yaml:"ntfy"`
+	Gotify      []GotifyConfig      `yaml:"gotify"`
+	UnifiedPush []UnifiedPushConfig `yaml:"unifiedpush"`
+
+	// Chat
+	Slack    []SlackConfig    `yaml:"slack"`
+	Discord  []DiscordConfig  `yaml:"discord"`
+	Matrix   []MatrixConfig   `yaml:"matrix"`
+	Telegram []TelegramConfig `yaml:"telegram"`
+}
+
+type WebhookConfig struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Signs the body in X-Salvator-Signature when set
+	Secret  string            `yaml:"secret"`
+	Headers map[string]string `yaml:"headers"`
+	// Go text/template over the message; the JSON message by default
+	Template    string `yaml:"template"`
+	ContentType string `yaml:"content_type"`
+}
+
+type EmailConfig struct {
+	Name string `yaml:"name"`
+	Host string `yaml:"host"`
+	// Defaults to 587, 465 or 25 depending on TLS
+	Port int `yaml:"port"`
+	// starttls (default), tls for implicit TLS, or none
+	TLS      string `yaml:"tls"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// plain (default) or login
+	Auth string   `yaml:"auth"`
+	From string   `yaml:"from"`
+	To   []string `yaml:"to"`
+	// Go templates over .Host and .Messages; built-in defaults when empty
+	TextTemplate string `yaml:"text_template"`
+	HTMLTemplate string `yaml:"html_template"`
+	// Batch alerts raised within this window into one email (0 sends each)
+	DigestWindow time.Duration `yaml:"digest_window"`
+}
+
+type NtfyConfig struct {
+	Name string `yaml:"name"`
+	// Server base URL (default https://ntfy.sh)
+	URL   string `yaml:"url"`
+	Topic string `yaml:"topic"`
+	Token string `yaml:"token"`
+	// Priority (1-5) per severity or "resolved", overriding the defaults
+	Priorities map[string]int `yaml:"priorities"`
+}
+
+type GotifyConfig struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Application token
+	Token string `yaml:"token"`
+	// Priority (0-10) per severity or "resolved", overriding the defaults
+	Priorities map[string]int `yaml:"priorities"`
+}
+
+type UnifiedPushConfig struct {
+	Name string `yaml:"name"`
+	// Endpoint handed out by the distributor to the app
+	Endpoint string `yaml:"endpoint"`
+	Token    string `yaml:"token"`
+}
+
+type SlackConfig struct {
+	Name string `yaml:"name"`
+	// Incoming webhook; Mattermost and Rocket.Chat accept the same format
+	WebhookURL string `yaml:"webhook_url"`
+	// Bot token and channel for chat.postMessage, which allows threading
+	Token   string `yaml:"token"`
+	Channel string `yaml:"channel"`
+	// Web API base (default https://slack.com/api)
+	APIURL string `yaml:"api_url"`
+}
+
+type DiscordConfig struct {
+	Name       string `yaml:"name"`
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+type MatrixConfig struct {
+	Name string `yaml:"name"`
+	// Client-server API base, e.g. https://matrix.example.com
+	Homeserver  string `yaml:"homeserver"`
+	AccessToken string `yaml:"access_token"`
+	RoomID      string `yaml:"room_id"`
+}
+
+type TelegramConfig struct {
+	Name string `yaml:"name"`
+	// Bot API base (default https://api.telegram.org)
+	APIURL   string `yaml:"api_url"`
+	BotToken string `yaml:"bot_token"`
+	ChatID   string `yaml:"chat_id"`
+}
+
+type HeartbeatConfig struct {
+	ID string `yaml:"id"`
+	// Expected time between pings, and how late a ping may be
+	Period time.Duration `yaml:"period"`
+	Grace  time.Duration `yaml:"grace"`
+	// How long a run may take after its start ping; defaults to period
+	MaxRuntime time.Duration `yaml:"max_runtime"`
+	// Token for /api/heartbeat/{id}; token_hash takes a bcrypt hash
+	Token     string `yaml:"token"`
+	TokenHash string `yaml:"token_hash"`
+}
+
+type RecorderConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Sampling interval and how much is kept in memory
+	Interval time.Duration `yaml:"interval"`
+	Window   time.Duration `yaml:"window"`
+	// How long to keep recording after an alert fires before the dump
+	After        time.Duration `yaml:"after"`
+	TopProcesses int           `yaml:"top_processes"`
+	// Oldest dumps are deleted beyond this many
+	MaxDumps int `yaml:"max_dumps"`
+}
+
 func defaultConfig() *Config {
 	return &Config{
 		ListenAddress: ":8443",
@@ -56,6 +333,39 @@ func defaultConfig() *Config {
 		RefreshTTL:    7 * 24 * time.Hour,
 		ClientKey:     "",
 		ClientKeyHash: "",
+
+		SampleInterval:   2 * time.Second,
+		HistoryRetention: 3 * time.Hour,
+		Storage: StorageConfig{
+			Enabled: true,
+			Tiers: []StorageTier{
+				{Resolution: 0, Retention: 24 * time.Hour},
+				{Resolution: time.Minute, Retention: 30 * 24 * time.Hour},
+				{Resolution: time.Hour, Retention: 365 * 24 * time.Hour},
+			},
+		},
+		Ingest: IngestConfig{
+			FlushInterval: 10 * time.Second,
+			MaxSeries:     1000,
+			StatsD:        StatsDConfig{Address: "127.0.0.1:8125"},
+		},
+		Prometheus: PrometheusConfig{TopProcesses: 10},
+		OTLP: OTLPConfig{
+			Encoding:  "protobuf",
+			Interval:  30 * time.Second,
+			Timeout:   10 * time.Second,
+			BatchSize: 50,
+			MaxBuffer: 5000,
+		},
+		Notify: NotifyConfig{MaxAge: 24 * time.Hour},
+		Alerts: AlertsConfig{HistoryRetention: 90 * 24 * time.Hour},
+		Recorder: RecorderConfig{
+			Interval:     time.Second,
+			Window:       5 * time.Minute,
+			After:        30 * time.Second,
+			TopProcesses: 5,
+			MaxDumps:     50,
+		},
 	}
 }
 
@@ -91,6 +401,9 @@ func Load(path string) (*Config, error) {
 		}
 		cfg.JWTSecret = secret
 	}
+	if cfg.SampleInterval <= 0 {
+		cfg.SampleInterval = 2 * time.Second
+	}
 	if cfg.PasswordHash == "" {
 		// Default credentials for first boot; recommend overriding via env or config
 		if h, err := HashPassword("admin"); err == nil {
@@ -175,6 +488,21 @@ func applyEnvOverrides(cfg *Config) {
 			cfg.AllowedCIDRs = out
 		}
 	}
+	if v := os.Getenv("SERVER_MONITOR_SAMPLE_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.SampleInterval = d
+		}
+	}
+	if v := os.Getenv("SERVER_MONITOR_HISTORY_RETENTION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.HistoryRetention = d
+		}
+	}
+	if v := os.Getenv("SERVER_MONITOR_PROMETHEUS_TOKEN"); v != "" {
+		if h, err := HashPassword(v); err == nil {
+			cfg.Prometheus.BearerTokenHash = h
+		}
+	}
 	if v := os.Getenv("SERVER_MONITOR_CLIENT_KEY"); v != "" {
 		if h, err := HashPassword(v); err == nil {
 			cfg.ClientKeyHash = h
@@ -0,0 +1,183 @@
+package diag
+
+import (
+	"context"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	gnet "github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+
+	"github.com/gofyr/server_monitor/server/internal/collector"
+)
+
+// Processes listed per ranking, and kernel log lines kept.
+const (
+	topProcesses   = 15
+	kernelLogLines = 50
+)
+
+// Process CPU is measured over this long.
+const cpuWindow = time.Second
+
+// Snapshot is the state of the host when an alert fired.
+type Snapshot struct {
+	AlertID     string                `json:"alert_id"`
+	Rule        string                `json:"rule"`
+	Series      string                `json:"series"`
+	Value       float64               `json:"value"`
+	Time        time.Time             `json:"time"`
+	TopCPU      []collector.Process   `json:"top_cpu"`
+	TopMemory   []collector.Process   `json:"top_memory"`
+	Connections []Connection          `json:"connections"`
+	Services    []collector.Service   `json:"services"`
+	Containers  []collector.Container `json:"containers"`
+	KernelLog   []string              `json:"kernel_log"`
+	// Parts that could not be captured, by name
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Connection is an established network connection.
+type Connection struct {
+	Protocol   string `json:"protocol"`
+	LocalIP    string `json:"laddr_ip"`
+	LocalPort  uint32 `json:"laddr_port"`
+	RemoteIP   string `json:"raddr_ip"`
+	RemotePort uint32 `json:"raddr_port"`
+	PID        int32  `json:"pid"`
+	Process    string `json:"process"`
+}
+
+// capture fills in everything but the alert fields. Every part is captured
+// even when others fail.
+func capture(ctx context.Context, reg *collector.Registry) *Snapshot {
+	s := &Snapshot{
+		Time:        time.Now(),
+		TopCPU:      []collector.Process{},
+		TopMemory:   []collector.Process{},
+		Connections: []Connection{},
+		Services:    []collector.Service{},
+		Containers:  []collector.Container{},
+		KernelLog:   []string{},
+		Errors:      map[string]string{},
+	}
+	names := map[int32]string{}
+	recent, cpuErr := recentCPU(ctx, cpuWindow)
+	if procs, err := collector.ListProcesses(ctx); err != nil {
+		s.Errors["processes"] = err.Error()
+	} else if cpuErr != nil {
+		s.Errors["processes"] = cpuErr.Error()
+	} else {
+		for i, p := range procs {
+			names[p.PID] = p.Name
+			procs[i].CPU = recent[p.PID]
+		}
+		sort.Slice(procs, func(i, j int) bool { return procs[i].CPU > procs[j].CPU })
+		s.TopCPU = append(s.TopCPU, procs[:min(topProcesses, len(procs))]...)
+		sort.Slice(procs, func(i, j int) bool { return procs[i].Memory > procs[j].Memory })
+		s.TopMemory = append(s.TopMemory, procs[:min(topProcesses, len(procs))]...)
+	}
+	if conns, err := gnet.ConnectionsWithContext(ctx, "inet"); err != nil {
+		s.Errors["connections"] = err.Error()
+	} else {
+		for _, c := range conns {
+			if c.Status != "ESTABLISHED" {
+				continue
+			}
+			proto := "tcp"
+			if c.Type == syscall.SOCK_DGRAM {
+				proto = "udp"
+			}
+			s.Connections = append(s.Connections, Connection{
+				Protocol:   proto,
+				LocalIP:    c.Laddr.IP,
+				LocalPort:  c.Laddr.Port,
+				RemoteIP:   c.Raddr.IP,
+				RemotePort: c.Raddr.Port,
+				PID:        c.Pid,
+				Process:    names[c.Pid],
+			})
+		}
+	}
+	if v, _, err := reg.Value(collector.Services); err == nil {
+		s.Services = v.([]collector.Service)
+	} else if err != collector.ErrDisabled {
+		s.Errors[collector.Services] = err.Error()
+	}
+	if v, _, err := reg.Value(collector.Containers); err == nil {
+		s.Containers = v.([]collector.Container)
+	} else if err != collector.ErrDisabled {
+		s.Errors[collector.Containers] = err.Error()
+	}
+	if lines, err := kernelLog(ctx); err != nil {
+		s.Errors["kernel_log"] = err.Error()
+	} else {
+		s.KernelLog = lines
+	}
+	if len(s.Errors) == 0 {
+		s.Errors = nil
+	}
+	return s
+}
+
+// recentCPU measures the CPU use of every process over window, in percent
+// of one core. The CPU reported by ListProcesses is the average over each
+// process's lifetime, which hides what is busy right now.
+func recentCPU(ctx context.Context, window time.Duration) (map[int32]float64, error) {
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	procs := make(map[int32]*process.Process, len(pids))
+	for _, pid := range pids {
+		p, err := process.NewProcessWithContext(ctx, pid)
+		if err != nil {
+			continue
+		}
+		// The first call only records the current CPU times
+		if _, err := p.PercentWithContext(ctx, 0); err == nil {
+			procs[pid] = p
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(window):
+	}
+	out := make(map[int32]float64, len(procs))
+	for pid, p := range procs {
+		if pct, err := p.PercentWithContext(ctx, 0); err == nil {
+			out[pid] = pct
+		}
+	}
+	return out, nil
+}
+
+// kernelLog returns the last kernel messages from the journal or, without
+// systemd, from dmesg.
+func kernelLog(ctx context.Context) ([]string, error) {
+	if _, err := exec.LookPath("journalctl"); err == nil {
+		b, err := exec.CommandContext(ctx, "journalctl", "-k", "-q", "--no-pager", "-o", "short-iso", "-n", strconv.Itoa(kernelLogLines)).Output()
+		if err == nil {
+			return lastLines(b), nil
+		}
+	}
+	b, err := exec.CommandContext(ctx, "dmesg", "-T").Output()
+	if err != nil {
+		return nil, err
+	}
+	return lastLines(b), nil
+}
+
+func lastLines(b []byte) []string {
+	text := strings.TrimRight(string(b), "\n")
+	if text == "" {
+		return []string{}
+	}
+	lines := strings.Split(text, "\n")
+	return lines[max(0, len(lines)-kernelLogLines):]
+}
@@ -0,0 +1,124 @@
+// Package diag captures a diagnostic snapshot of the host when an alert
+// starts firing, so the cause can be inspected after the fact.
+package diag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/alert"
+	"github.com/gofyr/server_monitor/server/internal/collector"
+)
+
+// A capture must finish within this time.
+const captureTimeout = 30 * time.Second
+
+var ErrNotFound = errors.New("snapshot not found")
+
+// Store captures snapshots one at a time in the background and keeps them
+// as JSON files named by alert ID.
+type Store struct {
+	dir       string
+	retention time.Duration
+	reg       *collector.Registry
+	queue     chan alert.Alert
+}
+
+func Open(dir string, retention time.Duration, reg *collector.Registry) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, retention: retention, reg: reg, queue: make(chan alert.Alert, 32)}
+	s.prune()
+	return s, nil
+}
+
+// Trigger queues a capture for a firing alert and returns the API path it
+// will be served at. It never blocks; when captures pile up during an alert
+// storm the excess are dropped.
+func (s *Store) Trigger(a alert.Alert) string {
+	select {
+	case s.queue <- a:
+	default:
+		log.Printf("diag: capture queue full, no snapshot for alert %s", a.ID)
+		return ""
+	}
+	return "/api/alerts/" + a.ID + "/snapshot"
+}
+
+// Run captures queued snapshots until ctx is cancelled.
+func (s *Store) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case a := <-s.queue:
+			cctx, cancel := context.WithTimeout(ctx, captureTimeout)
+			snap := capture(cctx, s.reg)
+			cancel()
+			snap.AlertID, snap.Rule, snap.Series, snap.Value = a.ID, a.Rule, a.Series, a.Value
+			if err := s.save(snap); err != nil {
+				log.Printf("diag: saving snapshot for alert %s: %v", a.ID, err)
+			}
+			s.prune()
+		}
+	}
+}
+
+func (s *Store) save(snap *Snapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, snap.AlertID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Get loads the snapshot of an alert.
+func (s *Store) Get(id string) (*Snapshot, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	b, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// prune removes snapshots older than the retention.
+func (s *Store) prune() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-s.retention)
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+}
+
+// validID reports whether id looks like an alert ID, so it is safe to use
+// as a file name.
+func validID(id string) bool {
+	return id != "" && strings.Trim(id, "0123456789abcdef") == ""
+}
@@ -0,0 +1,131 @@
+// Package forecast extrapolates metric trends, e.g. to predict when a disk
+// fills up.
+package forecast
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/history"
+	"github.com/gofyr/server_monitor/server/internal/tsdb"
+)
+
+// DefaultWindow is how much history a trend is fitted to.
+const DefaultWindow = 24 * time.Hour
+
+// Trends are cached for this long per series and window.
+const refresh = time.Minute
+
+// Point is a value at a time.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Trend is a least-squares line through recent history.
+type Trend struct {
+	// Change per second
+	Slope float64
+	// Latest observed value and its time
+	Last   float64
+	LastAt time.Time
+}
+
+// PerDay is the slope per day.
+func (t Trend) PerDay() float64 { return t.Slope * 86400 }
+
+// TimeTo returns how long until the trend reaches limit, counted from the
+// latest observation. It reports false when the trend is not rising.
+func (t Trend) TimeTo(limit float64) (time.Duration, bool) {
+	if t.Slope <= 0 {
+		return 0, false
+	}
+	if t.Last >= limit {
+		return 0, true
+	}
+	secs := (limit - t.Last) / t.Slope
+	if secs > float64(math.MaxInt64/int64(time.Second)) {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
+
+// Fit fits a line through points. At least five points spanning a quarter
+// of window are needed.
+func Fit(points []Point, window time.Duration) (Trend, bool) {
+	if len(points) < 5 || points[len(points)-1].Time.Sub(points[0].Time) < window/4 {
+		return Trend{}, false
+	}
+	t0 := points[0].Time
+	var sx, sy, sxx, sxy float64
+	for _, p := range points {
+		x := p.Time.Sub(t0).Seconds()
+		sx += x
+		sy += p.Value
+		sxx += x * x
+		sxy += x * p.Value
+	}
+	n := float64(len(points))
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return Trend{}, false
+	}
+	last := points[len(points)-1]
+	return Trend{Slope: (n*sxy - sx*sy) / den, Last: last.Value, LastAt: last.Time}, true
+}
+
+type cached struct {
+	trend Trend
+	ok    bool
+	at    time.Time
+}
+
+// Forecaster fits trends to series history from the persistent store or,
+// when storage is disabled, the in-memory ring.
+type Forecaster struct {
+	db   *tsdb.DB
+	ring *history.Ring
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+func New(db *tsdb.DB, ring *history.Ring) *Forecaster {
+	return &Forecaster{db: db, ring: ring, cache: map[string]cached{}}
+}
+
+// Trend returns the trend of series key over the window before now.
+func (f *Forecaster) Trend(key string, window time.Duration, now time.Time) (Trend, bool) {
+	ck := key + "\x00" + window.String()
+	f.mu.Lock()
+	c, hit := f.cache[ck]
+	f.mu.Unlock()
+	if hit && now.Sub(c.at) < refresh {
+		return c.trend, c.ok
+	}
+	trend, ok := Fit(f.points(key, now.Add(-window), now, window/100), window)
+	f.mu.Lock()
+	f.cache[ck] = cached{trend: trend, ok: ok, at: now}
+	f.mu.Unlock()
+	return trend, ok
+}
+
+func (f *Forecaster) points(key string, from, to time.Time, step time.Duration) []Point {
+	var out []Point
+	if f.db != nil {
+		res, err := f.db.Query([]string{key}, from, to, step)
+		if err == nil {
+			for _, p := range res[key] {
+				out = append(out, Point{Time: p.Time, Value: p.Avg})
+			}
+			return out
+		}
+	}
+	for _, s := range f.ring.Range(from, to, step) {
+		if v, ok := s.Series()[key]; ok {
+			out = append(out, Point{Time: s.Time, Value: v})
+		}
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/alert"
+	"github.com/gofyr/server_monitor/server/internal/diag"
+	"github.com/gofyr/server_monitor/server/internal/middleware"
+	"github.com/gorilla/mux"
+)
+
+// AlertsHandler lists active and recently resolved alerts, optionally
+// filtered by ?state=.
+func AlertsHandler(engine *alert.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alerts := engine.Alerts()
+		if state := r.URL.Query().Get("state"); state != "" {
+			filtered := alerts[:0]
+			for _, a := range alerts {
+				if string(a.State) == state {
+					filtered = append(filtered, a)
+				}
+			}
+			alerts = filtered
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alerts)
+	}
+}
+
+type ackRequest struct {
+	Comment string `json:"comment"`
+}
+
+// AckAlertHandler acknowledges an alert on behalf of the logged-in user.
+func AckAlertHandler(engine *alert.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ackRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+		}
+		a, err := engine.Ack(mux.Vars(r)["id"], middleware.UsernameFromContext(r), req.Comment)
+		switch err {
+		case nil:
+		case alert.ErrNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		default:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+// AlertSnapshotHandler serves the diagnostic snapshot captured when an
+// alert fired.
+func AlertSnapshotHandler(store *diag.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap, err := store.Get(mux.Vars(r)["id"])
+		switch err {
+		case nil:
+		case diag.ErrNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		default:
+			http.Error(w, "snapshot unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snap)
+	}
+}
+
+// AlertHistoryHandler lists alerts active between from and to (default the
+// last 24 hours).
+func AlertHistoryHandler(history *alert.History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		now := time.Now()
+		from, err := parseTimeParam(q.Get("from"), now.Add(-24*time.Hour))
+		if err != nil {
+			http.Error(w, "invalid from", http.StatusBadRequest)
+			return
+		}
+		to, err := parseTimeParam(q.Get("to"), now)
+		if err != nil {
+			http.Error(w, "invalid to", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history.Query(from, to))
+	}
+}
+
+// AlertStreamHandler streams alert transitions as server-sent events named
+// after the event type (pending, firing, resolved, acknowledged).
+func AlertStreamHandler(engine *alert.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		// Alert streams are mostly idle, so lift the server's write timeout
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
+		ch, unsubscribe := engine.Subscribe()
+		defer unsubscribe()
+		keepalive := time.NewTicker(30 * time.Second)
+		defer keepalive.Stop()
+		fmt.Fprint(w, ": connected\n\n")
+		rc.Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			case ev := <-ch:
+				b, _ := json.Marshal(ev)
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, b)
+			}
+			rc.Flush()
+		}
+	}
+}
+
+// AlertBaselinesHandler returns the baseline bands learned by anomaly
+// rules. ?rule= and ?series= narrow the result; ?points=1 adds the recent
+// band history for charting.
+func AlertBaselinesHandler(engine *alert.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		withPoints := q.Get("points") == "1" || q.Get("points") == "true"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(engine.Baselines(q.Get("rule"), q.Get("series"), withPoints))
+	}
+}
@@ -7,13 +7,19 @@ import (
 
 	"github.com/gofyr/server_monitor/server/internal/auth"
 	"github.com/gofyr/server_monitor/server/internal/config"
+	"github.com/gofyr/server_monitor/server/internal/middleware"
+	"github.com/gofyr/server_monitor/server/internal/sessions"
+	"github.com/gofyr/server_monitor/server/internal/users"
 )
 
 type refreshRequest struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-func RefreshHandler(jwtManager *auth.JWTManager) http.HandlerFunc {
+// RefreshHandler rotates the refresh token of a session and issues a new
+// token pair. The role is looked up again, so role changes and deleted
+// accounts take effect at the next refresh.
+func RefreshHandler(jwtManager *auth.JWTManager, accounts *users.Store, sess *sessions.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req refreshRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -25,7 +31,22 @@ func RefreshHandler(jwtManager *auth.JWTManager) http.HandlerFunc {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		access, refresh, err := jwtManager.IssuePair(claims.Username)
+		user, err := accounts.Get(claims.Username)
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		session, jti, err := sess.Rotate(claims.SessionID, claims.ID, middleware.ClientIP(r), r.UserAgent())
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if user.Predates(session.CreatedAt) {
+			sess.Revoke(session.ID, "")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		access, refresh, err := jwtManager.IssuePair(user.Username, user.Role, session.ID, jti)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
@@ -37,14 +58,16 @@ func RefreshHandler(jwtManager *auth.JWTManager) http.HandlerFunc {
 
 type meResponse struct {
 	Username     string `json:"username"`
+	Role         string `json:"role"`
 	DefaultCreds bool   `json:"default_creds"`
 }
 
 func MeHandler(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		isDefault := cfg.Username == "admin" && strings.TrimSpace(cfg.PasswordHash) == ""
+		username := middleware.UsernameFromContext(r)
+		isDefault := username == cfg.Username && cfg.Username == "admin" && strings.TrimSpace(cfg.PasswordHash) == ""
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(meResponse{Username: cfg.Username, DefaultCreds: isDefault})
+		json.NewEncoder(w).Encode(meResponse{Username: username, Role: middleware.RoleFromContext(r), DefaultCreds: isDefault})
 	}
 }
 
@@ -53,7 +76,8 @@ type changeCredsRequest struct {
 	NewPassword string `json:"new_password"`
 }
 
-func ChangeCredentialsHandler(jwtManager *auth.JWTManager, cfg *config.Config) http.HandlerFunc {
+// ChangeCredentialsHandler replaces the account defined in the config file.
+func ChangeCredentialsHandler(jwtManager *auth.JWTManager, cfg *config.Config, accounts *users.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req changeCredsRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -64,6 +88,12 @@ func ChangeCredentialsHandler(jwtManager *auth.JWTManager, cfg *config.Config) h
 			http.Error(w, "invalid input", http.StatusBadRequest)
 			return
 		}
+		if req.Username != cfg.Username {
+			if _, err := accounts.Get(req.Username); err == nil {
+				http.Error(w, users.ErrExists.Error(), http.StatusConflict)
+				return
+			}
+		}
 		hash, err := config.HashPassword(req.NewPassword)
 		if err != nil {
 			http.Error(w, "hash error", http.StatusInternalServerError)
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/heartbeat"
+	"github.com/gorilla/mux"
+)
+
+// HeartbeatPingHandler records a ping from a job. It authenticates with
+// the heartbeat's own token, given as a bearer token or ?token=, instead
+// of a login. ?duration= (seconds or a Go duration) reports the run time.
+func HeartbeatPingHandler(mon *heartbeat.Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		kind := vars["kind"]
+		if kind == "" {
+			kind = heartbeat.PingSuccess
+		}
+		token := r.URL.Query().Get("token")
+		if authz := r.Header.Get("Authorization"); strings.HasPrefix(strings.ToLower(authz), "bearer ") {
+			token = strings.TrimSpace(authz[len("Bearer "):])
+		}
+		duration, err := parseDurationParam(r.URL.Query().Get("duration"))
+		if err != nil {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		switch err := mon.Ping(vars["id"], token, kind, duration); err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case heartbeat.ErrNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	}
+}
+
+func HeartbeatsHandler(mon *heartbeat.Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mon.List())
+	}
+}
+
+type heartbeatRequest struct {
+	ID         string `json:"id"`
+	Period     string `json:"period"`
+	Grace      string `json:"grace"`
+	MaxRuntime string `json:"max_runtime"`
+}
+
+type heartbeatCreated struct {
+	heartbeat.Heartbeat
+	// Only returned here
+	Token string `json:"token"`
+}
+
+func CreateHeartbeatHandler(mon *heartbeat.Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req heartbeatRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		period, err := time.ParseDuration(req.Period)
+		if err != nil {
+			http.Error(w, "invalid period", http.StatusBadRequest)
+			return
+		}
+		var grace time.Duration
+		if req.Grace != "" {
+			if grace, err = time.ParseDuration(req.Grace); err != nil {
+				http.Error(w, "invalid grace", http.StatusBadRequest)
+				return
+			}
+		}
+		var maxRuntime time.Duration
+		if req.MaxRuntime != "" {
+			if maxRuntime, err = time.ParseDuration(req.MaxRuntime); err != nil {
+				http.Error(w, "invalid max_runtime", http.StatusBadRequest)
+				return
+			}
+		}
+		hb, token, err := mon.Create(req.ID, period, grace, maxRuntime)
+		if err != nil {
+			status := http.StatusBadRequest
+			if err == heartbeat.ErrExists {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(heartbeatCreated{Heartbeat: hb, Token: token})
+	}
+}
+
+func DeleteHeartbeatHandler(mon *heartbeat.Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch err := mon.Delete(mux.Vars(r)["id"]); err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case heartbeat.ErrNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+	}
+}
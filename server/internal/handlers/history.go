@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/history"
+	"github.com/gofyr/server_monitor/server/internal/sampler"
+	"github.com/gofyr/server_monitor/server/internal/tsdb"
+)
+
+type HistorySample struct {
+	Timestamp int64 `json:"timestamp"`
+	sampler.Metrics
+}
+
+type HistoryPoint struct {
+	Timestamp int64 `json:"timestamp"`
+	tsdb.Point
+}
+
+type HistoryResponse struct {
+	From    int64           `json:"from"`
+	To      int64           `json:"to"`
+	Step    float64         `json:"step"`
+	Samples []HistorySample `json:"samples"`
+}
+
+type SeriesHistoryResponse struct {
+	From   int64                     `json:"from"`
+	To     int64                     `json:"to"`
+	Step   float64                   `json:"step"`
+	Series map[string][]HistoryPoint `json:"series"`
+}
+
+// MetricsHistoryHandler serves past snapshots. from and to accept RFC 3339
+// or unix seconds and default to the whole retained window; step accepts a
+// Go duration or seconds. Timestamps in the response are unix milliseconds.
+//
+// Recent samples come from the in-memory ring; anything older is read from
+// the persistent store when one is configured. With one or more series
+// parameters the response instead holds avg/min/max points per series key
+// from the persistent store.
+func MetricsHistoryHandler(ring *history.Ring, db *tsdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		now := time.Now()
+		oldest, ok := ring.Oldest()
+		if !ok {
+			oldest = now
+		}
+		from, err := parseTimeParam(q.Get("from"), oldest)
+		if err != nil {
+			http.Error(w, "invalid from", http.StatusBadRequest)
+			return
+		}
+		to, err := parseTimeParam(q.Get("to"), now)
+		if err != nil {
+			http.Error(w, "invalid to", http.StatusBadRequest)
+			return
+		}
+		step, err := parseDurationParam(q.Get("step"))
+		if err != nil || step < 0 {
+			http.Error(w, "invalid step", http.StatusBadRequest)
+			return
+		}
+		if to.Before(from) {
+			http.Error(w, "from must not be after to", http.StatusBadRequest)
+			return
+		}
+		if keys := q["series"]; len(keys) > 0 {
+			if db == nil {
+				http.Error(w, "persistent storage disabled", http.StatusNotFound)
+				return
+			}
+			res, err := db.Query(keys, from, to, step)
+			if err != nil {
+				http.Error(w, "history error", http.StatusInternalServerError)
+				return
+			}
+			out := SeriesHistoryResponse{From: from.UnixMilli(), To: to.UnixMilli(), Step: step.Seconds()}
+			out.Series = make(map[string][]HistoryPoint, len(keys))
+			for _, k := range keys {
+				pts := make([]HistoryPoint, 0, len(res[k]))
+				for _, p := range res[k] {
+					pts = append(pts, HistoryPoint{Timestamp: p.Time.UnixMilli(), Point: p})
+				}
+				out.Series[k] = pts
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(out)
+			return
+		}
+		out := HistoryResponse{From: from.UnixMilli(), To: to.UnixMilli(), Step: step.Seconds()}
+		out.Samples = make([]HistorySample, 0)
+		if db != nil && from.Before(oldest) {
+			end := oldest.Add(-time.Millisecond)
+			if to.Before(end) {
+				end = to
+			}
+			older, err := storedSamples(db, from, end, step)
+			if err != nil {
+				http.Error(w, "history error", http.StatusInternalServerError)
+				return
+			}
+			out.Samples = append(out.Samples, older...)
+		}
+		for _, s := range ring.Range(from, to, step) {
+			out.Samples = append(out.Samples, HistorySample{Timestamp: s.Time.UnixMilli(), Metrics: s.Metrics})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// storedSamples rebuilds whole-snapshot samples from the bucket averages
+// of the persistent store.
+func storedSamples(db *tsdb.DB, from, to time.Time, step time.Duration) ([]HistorySample, error) {
+	res, err := db.Query(nil, from, to, step)
+	if err != nil {
+		return nil, err
+	}
+	byTime := map[int64]map[string]float64{}
+	for k, pts := range res {
+		for _, p := range pts {
+			ts := p.Time.UnixMilli()
+			if byTime[ts] == nil {
+				byTime[ts] = map[string]float64{}
+			}
+			byTime[ts][k] = p.Avg
+		}
+	}
+	stamps := make([]int64, 0, len(byTime))
+	for ts := range byTime {
+		stamps = append(stamps, ts)
+	}
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i] < stamps[j] })
+	out := make([]HistorySample, 0, len(stamps))
+	for _, ts := range stamps {
+		out = append(out, HistorySample{Timestamp: ts, Metrics: sampler.MetricsFromSeries(byTime[ts])})
+	}
+	return out, nil
+}
+
+func parseTimeParam(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.UnixMilli(int64(secs * 1000)), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func parseDurationParam(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return time.ParseDuration(v)
+}
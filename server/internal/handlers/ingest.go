@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gofyr/server_monitor/server/internal/ingest"
+)
+
+type ingestMetric struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	Value  float64           `json:"value"`
+	Labels map[string]string `json:"labels"`
+}
+
+type ingestRequest struct {
+	Metrics []ingestMetric `json:"metrics"`
+}
+
+// IngestHandler accepts gauges and counters pushed by applications, e.g.
+// {"metrics":[{"name":"queue_depth","type":"gauge","value":12,"labels":{"queue":"mail"}}]}
+func IngestHandler(agg *ingest.Aggregator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ingestRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		samples := make([]ingest.Sample, 0, len(req.Metrics))
+		for _, m := range req.Metrics {
+			s := ingest.Sample{Name: m.Name, Labels: m.Labels, Value: m.Value}
+			switch m.Type {
+			case "gauge", "":
+				s.Kind = ingest.Gauge
+			case "counter":
+				s.Kind = ingest.Counter
+			default:
+				http.Error(w, "unsupported metric type: "+m.Type, http.StatusBadRequest)
+				return
+			}
+			samples = append(samples, s)
+		}
+		if err := agg.Add(samples); err != nil {
+			status := http.StatusBadRequest
+			if err == ingest.ErrTooManySeries {
+				status = http.StatusTooManyRequests
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"net/http"
 
 	"github.com/gofyr/server_monitor/server/internal/auth"
-	"github.com/gofyr/server_monitor/server/internal/config"
+	"github.com/gofyr/server_monitor/server/internal/middleware"
+	"github.com/gofyr/server_monitor/server/internal/sessions"
+	"github.com/gofyr/server_monitor/server/internal/users"
 )
 
 type loginRequest struct {
@@ -18,18 +20,25 @@ type tokenResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-func LoginHandler(jwtManager *auth.JWTManager, cfg *config.Config) http.HandlerFunc {
+// LoginHandler starts a session for valid credentials.
+func LoginHandler(jwtManager *auth.JWTManager, accounts *users.Store, sess *sessions.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req loginRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
-		if req.Username != cfg.Username || !config.CheckPassword(cfg.PasswordHash, req.Password) {
+		user, ok := accounts.Authenticate(req.Username, req.Password)
+		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		access, refresh, err := jwtManager.IssuePair(req.Username)
+		session, jti, err := sess.Create(user.Username, middleware.ClientIP(r), r.UserAgent())
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		access, refresh, err := jwtManager.IssuePair(user.Username, user.Role, session.ID, jti)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofyr/server_monitor/server/internal/collector"
+	"github.com/gofyr/server_monitor/server/internal/prom"
+	"github.com/gofyr/server_monitor/server/internal/sampler"
+	"github.com/gofyr/server_monitor/server/internal/series"
+)
+
+// PrometheusHandler exposes the latest snapshot and collector results in the
+// Prometheus text format, or OpenMetrics when the scraper asks for it.
+func PrometheusHandler(hub *sampler.Hub, reg *collector.Registry, topN int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := hub.Latest()
+		if snap == nil {
+			http.Error(w, "metrics not ready", http.StatusServiceUnavailable)
+			return
+		}
+		families := snapshotFamilies(snap)
+		families = append(families, registryFamilies(reg, topN)...)
+		families = append(families, extraFamilies(snap, families)...)
+		openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+		if openMetrics {
+			w.Header().Set("Content-Type", prom.OpenMetricsContentType)
+		} else {
+			w.Header().Set("Content-Type", prom.ContentType)
+		}
+		prom.Write(w, families, openMetrics)
+	}
+}
+
+func gauge(name, help string, v float64) prom.Family {
+	return prom.Family{Name: name, Help: help, Type: prom.GaugeType, Samples: []prom.Sample{{Value: v}}}
+}
+
+func snapshotFamilies(snap *sampler.Snapshot) []prom.Family {
+	m := &snap.Metrics
+	var out []prom.Family
+	has := snap.Present
+	if has(collector.CPU) {
+		out = append(out, gauge("salvator_cpu_usage_percent", "Total CPU usage in percent.", m.CPUPercent))
+		perCPU := prom.Family{Name: "salvator_cpu_core_usage_percent", Help: "Per-core CPU usage in percent.", Type: prom.GaugeType}
+		for i, v := range snap.PerCPU {
+			perCPU.Samples = append(perCPU.Samples, prom.Sample{Labels: map[string]string{"cpu": strconv.Itoa(i)}, Value: v})
+		}
+		out = append(out, perCPU)
+	}
+	if has(collector.Load) {
+		out = append(out,
+			gauge("salvator_load1", "1-minute load average.", m.Load1),
+			gauge("salvator_load5", "5-minute load average.", m.Load5),
+			gauge("salvator_load15", "15-minute load average.", m.Load15),
+		)
+	}
+	if has(collector.Memory) {
+		out = append(out,
+			gauge("salvator_memory_total_bytes", "Total physical memory.", float64(snap.Memory.Total)),
+			gauge("salvator_memory_used_bytes", "Used physical memory.", float64(snap.Memory.Used)),
+			gauge("salvator_memory_available_bytes", "Memory available for new allocations.", float64(snap.Memory.Available)),
+			gauge("salvator_swap_total_bytes", "Total swap space.", float64(snap.Swap.Total)),
+			gauge("salvator_swap_used_bytes", "Used swap space.", float64(snap.Swap.Used)),
+		)
+	}
+	if has(collector.Disk) {
+		usage := prom.Family{Name: "salvator_filesystem_usage_percent", Help: "Filesystem usage in percent.", Type: prom.GaugeType}
+		size := prom.Family{Name: "salvator_filesystem_size_bytes", Help: "Filesystem size.", Type: prom.GaugeType}
+		free := prom.Family{Name: "salvator_filesystem_free_bytes", Help: "Filesystem free space.", Type: prom.GaugeType}
+		for _, mt := range snap.Mounts {
+			l := map[string]string{"mountpoint": mt.Mountpoint, "device": mt.Device, "fstype": mt.Fstype}
+			usage.Samples = append(usage.Samples, prom.Sample{Labels: l, Value: mt.UsedPct})
+			size.Samples = append(size.Samples, prom.Sample{Labels: l, Value: float64(mt.Total)})
+			free.Samples = append(free.Samples, prom.Sample{Labels: l, Value: float64(mt.Free)})
+		}
+		out = append(out, usage, size, free)
+	}
+	if has(collector.DiskIO) {
+		read := prom.Family{Name: "salvator_disk_read_bytes_total", Help: "Bytes read from the block device.", Type: prom.CounterType}
+		written := prom.Family{Name: "salvator_disk_written_bytes_total", Help: "Bytes written to the block device.", Type: prom.CounterType}
+		reads := prom.Family{Name: "salvator_disk_reads_completed_total", Help: "Reads completed on the block device.", Type: prom.CounterType}
+		writes := prom.Family{Name: "salvator_disk_writes_completed_total", Help: "Writes completed on the block device.", Type: prom.CounterType}
+		for _, d := range snap.Disks {
+			l := map[string]string{"device": d.Name}
+			read.Samples = append(read.Samples, prom.Sample{Labels: l, Value: float64(d.ReadBytes)})
+			written.Samples = append(written.Samples, prom.Sample{Labels: l, Value: float64(d.WriteBytes)})
+			reads.Samples = append(reads.Samples, prom.Sample{Labels: l, Value: float64(d.Reads)})
+			writes.Samples = append(writes.Samples, prom.Sample{Labels: l, Value: float64(d.Writes)})
+		}
+		out = append(out, read, written, reads, writes)
+	}
+	if has(collector.Network) {
+		rx := prom.Family{Name: "salvator_network_receive_bytes_total", Help: "Bytes received on the interface.", Type: prom.CounterType}
+		tx := prom.Family{Name: "salvator_network_transmit_bytes_total", Help: "Bytes sent on the interface.", Type: prom.CounterType}
+		rxp := prom.Family{Name: "salvator_network_receive_packets_total", Help: "Packets received on the interface.", Type: prom.CounterType}
+		txp := prom.Family{Name: "salvator_network_transmit_packets_total", Help: "Packets sent on the interface.", Type: prom.CounterType}
+		rxe := prom.Family{Name: "salvator_network_receive_errors_total", Help: "Receive errors on the interface.", Type: prom.CounterType}
+		txe := prom.Family{Name: "salvator_network_transmit_errors_total", Help: "Transmit errors on the interface.", Type: prom.CounterType}
+		for _, i := range snap.Interfaces {
+			l := map[string]string{"interface": i.Name}
+			rx.Samples = append(rx.Samples, prom.Sample{Labels: l, Value: float64(i.BytesRecv)})
+			tx.Samples = append(tx.Samples, prom.Sample{Labels: l, Value: float64(i.BytesSent)})
+			rxp.Samples = append(rxp.Samples, prom.Sample{Labels: l, Value: float64(i.PacketsRecv)})
+			txp.Samples = append(txp.Samples, prom.Sample{Labels: l, Value: float64(i.PacketsSent)})
+			rxe.Samples = append(rxe.Samples, prom.Sample{Labels: l, Value: float64(i.ErrIn)})
+			txe.Samples = append(txe.Samples, prom.Sample{Labels: l, Value: float64(i.ErrOut)})
+		}
+		out = append(out, rx, tx, rxp, txp, rxe, txe)
+	}
+	if has(collector.Host) && m.BootTime > 0 {
+		out = append(out,
+			gauge("salvator_boot_time_seconds", "Unix time the host booted.", float64(m.BootTime)),
+			gauge("salvator_uptime_seconds", "Seconds since the host booted.", float64(m.Uptime)),
+		)
+	}
+	return out
+}
+
+func registryFamilies(reg *collector.Registry, topN int) []prom.Family {
+	var out []prom.Family
+	up := prom.Family{Name: "salvator_collector_up", Help: "Whether the collector's last run succeeded.", Type: prom.GaugeType}
+	dur := prom.Family{Name: "salvator_collector_duration_seconds", Help: "Duration of the collector's last run.", Type: prom.GaugeType}
+	for _, st := range reg.Statuses() {
+		if !st.Enabled {
+			continue
+		}
+		l := map[string]string{"collector": st.Name}
+		v := 1.0
+		if st.Error != "" {
+			v = 0
+		}
+		up.Samples = append(up.Samples, prom.Sample{Labels: l, Value: v})
+		dur.Samples = append(dur.Samples, prom.Sample{Labels: l, Value: st.DurationMS / 1000})
+	}
+	out = append(out, up, dur)
+
+	if v, _, err := reg.Value(collector.Services); err == nil {
+		f := prom.Family{Name: "salvator_service_active", Help: "Whether the systemd service is active.", Type: prom.GaugeType}
+		for _, s := range v.([]collector.Service) {
+			active := 0.0
+			if s.Active == "active" {
+				active = 1
+			}
+			f.Samples = append(f.Samples, prom.Sample{Labels: map[string]string{"service": s.Name, "state": s.Active, "sub": s.Sub}, Value: active})
+		}
+		out = append(out, f)
+	}
+	if v, _, err := reg.Value(collector.Containers); err == nil {
+		f := prom.Family{Name: "salvator_container_running", Help: "Whether the container is running.", Type: prom.GaugeType}
+		for _, c := range v.([]collector.Container) {
+			running := 0.0
+			if strings.HasPrefix(c.State, "Up") {
+				running = 1
+			}
+			f.Samples = append(f.Samples, prom.Sample{Labels: map[string]string{"id": c.ID, "name": c.Name, "image": c.Image}, Value: running})
+		}
+		out = append(out, f)
+	}
+	if v, _, err := reg.Value(collector.Processes); err == nil && topN > 0 {
+		cpu := prom.Family{Name: "salvator_process_cpu_percent", Help: "CPU usage of the top processes since the previous collection.", Type: prom.GaugeType}
+		rss := prom.Family{Name: "salvator_process_resident_memory_bytes", Help: "Resident memory of the top processes.", Type: prom.GaugeType}
+		for _, p := range topProcesses(v.([]collector.Process), topN) {
+			l := map[string]string{"pid": strconv.Itoa(int(p.PID)), "name": p.Name, "user": p.Username}
+			cpu.Samples = append(cpu.Samples, prom.Sample{Labels: l, Value: p.CPU})
+			rss.Samples = append(rss.Samples, prom.Sample{Labels: l, Value: float64(p.Memory)})
+		}
+		out = append(out, cpu, rss)
+	}
+	return out
+}
+
+// topProcesses returns the union of the n busiest processes by CPU and by
+// resident memory.
+func topProcesses(list []collector.Process, n int) []collector.Process {
+	byCPU := append([]collector.Process(nil), list...)
+	sort.Slice(byCPU, func(i, j int) bool { return byCPU[i].CPU > byCPU[j].CPU })
+	byMem := append([]collector.Process(nil), list...)
+	sort.Slice(byMem, func(i, j int) bool { return byMem[i].Memory > byMem[j].Memory })
+	seen := map[int32]bool{}
+	var out []collector.Process
+	for _, l := range [][]collector.Process{byCPU, byMem} {
+		for i := 0; i < n && i < len(l); i++ {
+			if !seen[l[i].PID] {
+				seen[l[i].PID] = true
+				out = append(out, l[i])
+			}
+		}
+	}
+	return out
+}
+
+// extraFamilies exports series contributed by checks, ingestion and other
+// sources as untyped metrics prefixed with salvator_. Series whose name
+// would repeat one of the builtin families are skipped, since a duplicate
+// family invalidates the whole scrape.
+func extraFamilies(snap *sampler.Snapshot, builtin []prom.Family) []prom.Family {
+	taken := make(map[string]bool, len(builtin))
+	for _, f := range builtin {
+		taken[f.Name] = true
+	}
+	byName := map[string]*prom.Family{}
+	for key, v := range snap.Extra() {
+		name, labels, err := series.Parse(key)
+		if err != nil {
+			continue
+		}
+		name = "salvator_" + prom.SanitizeName(name)
+		if taken[name] {
+			continue
+		}
+		f, ok := byName[name]
+		if !ok {
+			f = &prom.Family{Name: name, Type: prom.UntypedType}
+			byName[name] = f
+		}
+		clean := make(map[string]string, len(labels))
+		for k, lv := range labels {
+			clean[prom.SanitizeName(k)] = lv
+		}
+		f.Samples = append(f.Samples, prom.Sample{Labels: clean, Value: v})
+	}
+	names := make([]string, 0, len(byName))
+	for n := range byName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	out := make([]prom.Family, 0, len(names))
+	for _, n := range names {
+		out = append(out, *byName[n])
+	}
+	return out
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gofyr/server_monitor/server/internal/middleware"
+	"github.com/gofyr/server_monitor/server/internal/recorder"
+	"github.com/gorilla/mux"
+)
+
+// RecorderDumpsHandler lists flight recorder dumps without their samples.
+func RecorderDumpsHandler(rec *recorder.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rec.List())
+	}
+}
+
+func RecorderDumpHandler(rec *recorder.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := rec.Get(mux.Vars(r)["id"])
+		switch err {
+		case nil:
+		case recorder.ErrNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		default:
+			http.Error(w, "dump unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// CreateRecorderDumpHandler freezes what the flight recorder holds now.
+func CreateRecorderDumpHandler(rec *recorder.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := rec.Dump(middleware.UsernameFromContext(r))
+		if err != nil {
+			http.Error(w, "dump failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(d)
+	}
+}
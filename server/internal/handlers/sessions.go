@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gofyr/server_monitor/server/internal/auth"
+	"github.com/gofyr/server_monitor/server/internal/middleware"
+	"github.com/gofyr/server_monitor/server/internal/sessions"
+	"github.com/gorilla/mux"
+)
+
+type sessionView struct {
+	sessions.Session
+	// The session the request was made with
+	Current bool `json:"current,omitempty"`
+}
+
+// LogoutHandler ends the session of the access token used.
+func LogoutHandler(sess *sessions.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := middleware.SessionFromContext(r)
+		if id == "" {
+			http.Error(w, "not a login session", http.StatusBadRequest)
+			return
+		}
+		if err := sess.Revoke(id, ""); err != nil && err != sessions.ErrNotFound {
+			http.Error(w, "logout failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SessionsHandler lists the caller's sessions, or everyone's for admins.
+func SessionsHandler(sess *sessions.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := middleware.UsernameFromContext(r)
+		if auth.Allows(middleware.RoleFromContext(r), auth.RoleAdmin) {
+			owner = ""
+		}
+		current := middleware.SessionFromContext(r)
+		list := sess.List(owner)
+		out := make([]sessionView, 0, len(list))
+		for _, s := range list {
+			out = append(out, sessionView{Session: s, Current: s.ID == current})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// DeleteSessionHandler signs out one of the caller's sessions; admins can
+// end anyone's.
+func DeleteSessionHandler(sess *sessions.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := middleware.UsernameFromContext(r)
+		if auth.Allows(middleware.RoleFromContext(r), auth.RoleAdmin) {
+			owner = ""
+		}
+		switch err := sess.Revoke(mux.Vars(r)["id"], owner); err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case sessions.ErrNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case sessions.ErrForbidden:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "revoke failed", http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/alert"
+	"github.com/gofyr/server_monitor/server/internal/middleware"
+	"github.com/gorilla/mux"
+)
+
+type silenceRequest struct {
+	Matchers []alert.Matcher `json:"matchers"`
+	StartsAt time.Time       `json:"starts_at"`
+	EndsAt   time.Time       `json:"ends_at"`
+	// Alternative to ends_at, e.g. "2h"
+	Duration string `json:"duration"`
+	Comment  string `json:"comment"`
+}
+
+func SilencesHandler(silences *alert.Silences) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(silences.List())
+	}
+}
+
+// CreateSilenceHandler records the authenticated user as the creator.
+func CreateSilenceHandler(silences *alert.Silences) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req silenceRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		sil := alert.Silence{
+			Matchers:  req.Matchers,
+			StartsAt:  req.StartsAt,
+			EndsAt:    req.EndsAt,
+			Comment:   req.Comment,
+			CreatedBy: middleware.UsernameFromContext(r),
+		}
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid duration", http.StatusBadRequest)
+				return
+			}
+			start := req.StartsAt
+			if start.IsZero() {
+				start = time.Now()
+			}
+			sil.EndsAt = start.Add(d)
+		}
+		created, err := silences.Add(sil)
+		if errors.Is(err, alert.ErrInvalidSilence) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to save silence", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	}
+}
+
+func DeleteSilenceHandler(silences *alert.Silences) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := silences.Delete(mux.Vars(r)["id"])
+		if err == alert.ErrSilenceNotFound {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to save silences", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
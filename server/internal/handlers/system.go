@@ -11,22 +11,14 @@ import (
 	"context"
 	"time"
 
-	"github.com/coreos/go-systemd/v22/dbus"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/load"
-	"github.com/shirou/gopsutil/v3/mem"
 	gnet "github.com/shirou/gopsutil/v3/net"
 	"github.com/shirou/gopsutil/v3/process"
-)
 
-type Proc struct {
-	PID      int32   `json:"pid"`
-	Name     string  `json:"name"`
-	CPU      float64 `json:"cpu"`
-	Memory   uint64  `json:"memory"`
-	Username string  `json:"username"`
-}
+	"github.com/gofyr/server_monitor/server/internal/collector"
+	"github.com/gofyr/server_monitor/server/internal/forecast"
+	"github.com/gofyr/server_monitor/server/internal/sampler"
+	"github.com/gofyr/server_monitor/server/internal/series"
+)
 
 type DiskMount struct {
 	Device     string  `json:"device"`
@@ -36,147 +28,105 @@ type DiskMount struct {
 	Used       uint64  `json:"used"`
 	Free       uint64  `json:"free"`
 	UsedPct    float64 `json:"used_percent"`
-}
-
-type DiskIO struct {
-	Name       string `json:"name"`
-	ReadBytes  uint64 `json:"read_bytes"`
-	WriteBytes uint64 `json:"write_bytes"`
-	Reads      uint64 `json:"reads"`
-	Writes     uint64 `json:"writes"`
+	// Linear trend over the last day; omitted until there is enough history
+	GrowthPerDay    *float64   `json:"growth_bytes_per_day,omitempty"`
+	PredictedFullAt *time.Time `json:"predicted_full_at,omitempty"`
 }
 
 type DiskDetail struct {
-	Mounts []DiskMount `json:"mounts"`
-	IO     []DiskIO    `json:"io"`
+	Mounts []DiskMount          `json:"mounts"`
+	IO     []sampler.DiskIOStat `json:"io"`
+	Errors map[string]string    `json:"errors,omitempty"`
 }
 
-func DiskDetailHandler() http.HandlerFunc {
+func DiskDetailHandler(hub *sampler.Hub, fc *forecast.Forecaster) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		parts, _ := disk.Partitions(false)
-		mounts := make([]DiskMount, 0, len(parts))
-		for _, p := range parts {
-			if u, err := disk.Usage(p.Mountpoint); err == nil {
-				mounts = append(mounts, DiskMount{
-					Device:     p.Device,
-					Mountpoint: p.Mountpoint,
-					Fstype:     p.Fstype,
-					Total:      u.Total,
-					Used:       u.Used,
-					Free:       u.Free,
-					UsedPct:    u.UsedPercent,
-				})
+		snap := hub.Latest()
+		if snap == nil {
+			http.Error(w, "metrics not ready", http.StatusServiceUnavailable)
+			return
+		}
+		mounts := make([]DiskMount, 0, len(snap.Mounts))
+		for _, m := range snap.Mounts {
+			dm := DiskMount{
+				Device:     m.Device,
+				Mountpoint: m.Mountpoint,
+				Fstype:     m.Fstype,
+				Total:      m.Total,
+				Used:       m.Used,
+				Free:       m.Free,
+				UsedPct:    m.UsedPct,
 			}
+			key := series.Key("disk_usage", map[string]string{"mount": m.Mountpoint})
+			if trend, ok := fc.Trend(key, forecast.DefaultWindow, snap.Time); ok {
+				growth := trend.PerDay() / 100 * float64(m.Total)
+				dm.GrowthPerDay = &growth
+				if d, ok := trend.TimeTo(100); ok {
+					full := trend.LastAt.Add(d)
+					dm.PredictedFullAt = &full
+				}
+			}
+			mounts = append(mounts, dm)
 		}
-		ioStats, _ := disk.IOCounters()
-		ios := make([]DiskIO, 0, len(ioStats))
-		for name, st := range ioStats {
-			ios = append(ios, DiskIO{
-				Name:       name,
-				ReadBytes:  st.ReadBytes,
-				WriteBytes: st.WriteBytes,
-				Reads:      st.ReadCount,
-				Writes:     st.WriteCount,
-			})
+		ios := snap.Disks
+		if ios == nil {
+			ios = []sampler.DiskIOStat{}
 		}
-		out := DiskDetail{Mounts: mounts, IO: ios}
+		out := DiskDetail{Mounts: mounts, IO: ios, Errors: collectorErrors(snap, collector.Disk, collector.DiskIO)}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(out)
 	}
 }
 
-func ProcessesHandler() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		pids, _ := process.Pids()
-		list := make([]Proc, 0, len(pids))
-		for _, pid := range pids {
-			p, err := process.NewProcess(pid)
-			if err != nil {
-				continue
-			}
-			name, _ := p.Name()
-			cpu, _ := p.CPUPercent()
-			memInfo, _ := p.MemoryInfo()
-			user, _ := p.Username()
-			var mem uint64
-			if memInfo != nil {
-				mem = memInfo.RSS
+// collectorErrors picks the snapshot errors of the named collectors.
+func collectorErrors(snap *sampler.Snapshot, names ...string) map[string]string {
+	var out map[string]string
+	for _, n := range names {
+		if e, ok := snap.Metrics.Errors[n]; ok {
+			if out == nil {
+				out = map[string]string{}
 			}
-			list = append(list, Proc{PID: pid, Name: name, CPU: cpu, Memory: mem, Username: user})
+			out[n] = e
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(list)
 	}
+	return out
 }
 
-type Service struct {
-	Name   string `json:"name"`
-	Active string `json:"active"`
-	Sub    string `json:"sub"`
-}
-
-func ServicesHandler() http.HandlerFunc {
+func ProcessesHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
-		defer cancel()
-		conn, err := dbus.NewWithContext(ctx)
+		list, err := collector.ListProcesses(r.Context())
 		if err != nil {
-			http.Error(w, "systemd unavailable", http.StatusServiceUnavailable)
+			http.Error(w, "process list error", http.StatusInternalServerError)
 			return
 		}
-		units, err := conn.ListUnitsContext(ctx)
-		if err != nil {
-			http.Error(w, "systemd list error", http.StatusInternalServerError)
-			return
-		}
-		out := make([]Service, 0, len(units))
-		for _, u := range units {
-			if strings.HasSuffix(u.Name, ".service") {
-				out = append(out, Service{Name: u.Name, Active: u.ActiveState, Sub: u.SubState})
-			}
-		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(out)
+		json.NewEncoder(w).Encode(list)
 	}
 }
 
-type Container struct {
-	ID    string `json:"id"`
-	Image string `json:"image"`
-	Name  string `json:"name"`
-	State string `json:"state"`
+func ServicesHandler(reg *collector.Registry) http.HandlerFunc {
+	return collectorValueHandler(reg, collector.Services, []collector.Service{})
 }
 
-func ContainersHandler() http.HandlerFunc {
+func ContainersHandler(reg *collector.Registry) http.HandlerFunc {
+	return collectorValueHandler(reg, collector.Containers, []collector.Container{})
+}
+
+// collectorValueHandler serves the latest result of a collector, or empty
+// when the collector is disabled. A failing collector yields 503 with the
+// reason instead of an empty list.
+func collectorValueHandler[T any](reg *collector.Registry, name string, empty T) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
-		defer cancel()
-		bins := []string{"docker", "podman"}
-		var out []Container
-		for _, bin := range bins {
-			cmd := exec.CommandContext(ctx, bin, "ps", "--format", "{{.ID}}\t{{.Image}}\t{{.Names}}\t{{.Status}}")
-			b, err := cmd.Output()
-			if err != nil || len(b) == 0 {
-				continue
-			}
-			lines := strings.Split(strings.TrimSpace(string(b)), "\n")
-			out = make([]Container, 0, len(lines))
-			for _, ln := range lines {
-				if ln == "" {
-					continue
-				}
-				parts := strings.Split(ln, "\t")
-				if len(parts) >= 4 {
-					out = append(out, Container{ID: parts[0], Image: parts[1], Name: parts[2], State: parts[3]})
-				}
-			}
-			break
+		v, _, err := reg.Value(name)
+		switch {
+		case err == collector.ErrDisabled:
+			v = empty
+		case err != nil:
+			http.Error(w, name+" unavailable: "+err.Error(), http.StatusServiceUnavailable)
+			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		if out == nil {
-			out = []Container{}
-		}
-		json.NewEncoder(w).Encode(out)
+		json.NewEncoder(w).Encode(v)
 	}
 }
 
@@ -236,39 +186,40 @@ type MemDetail struct {
 }
 
 type SystemDetail struct {
-	PerCPU []float64 `json:"per_cpu"`
-	Load1  float64   `json:"load1"`
-	Load5  float64   `json:"load5"`
-	Load15 float64   `json:"load15"`
-	Memory MemDetail `json:"memory"`
+	PerCPU []float64         `json:"per_cpu"`
+	Load1  float64           `json:"load1"`
+	Load5  float64           `json:"load5"`
+	Load15 float64           `json:"load15"`
+	Memory MemDetail         `json:"memory"`
+	Errors map[string]string `json:"errors,omitempty"`
 }
 
-func SystemDetailHandler() http.HandlerFunc {
+func SystemDetailHandler(hub *sampler.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		per, _ := cpu.Percent(200*time.Millisecond, true)
-		l, _ := load.Avg()
-		vm, _ := mem.VirtualMemory()
-		sm, _ := mem.SwapMemory()
-		m := MemDetail{}
-		if vm != nil {
-			m.Total = vm.Total
-			m.Used = vm.Used
-			m.Free = vm.Free
-			m.Available = vm.Available
-			m.Buffers = vm.Buffers
-			m.Cached = vm.Cached
-			m.UsedPercent = vm.UsedPercent
+		snap := hub.Latest()
+		if snap == nil {
+			http.Error(w, "metrics not ready", http.StatusServiceUnavailable)
+			return
 		}
-		if sm != nil {
-			m.SwapTotal = sm.Total
-			m.SwapUsed = sm.Used
+		vm, sm := snap.Memory, snap.Swap
+		m := MemDetail{
+			Total:       vm.Total,
+			Used:        vm.Used,
+			Free:        vm.Free,
+			Available:   vm.Available,
+			Buffers:     vm.Buffers,
+			Cached:      vm.Cached,
+			UsedPercent: vm.UsedPercent,
+			SwapTotal:   sm.Total,
+			SwapUsed:    sm.Used,
 		}
 		out := SystemDetail{
-			PerCPU: per,
+			PerCPU: snap.PerCPU,
+			Load1:  snap.Metrics.Load1,
+			Load5:  snap.Metrics.Load5,
+			Load15: snap.Metrics.Load15,
 			Memory: m,
-		}
-		if l != nil {
-			out.Load1, out.Load5, out.Load15 = l.Load1, l.Load5, l.Load15
+			Errors: collectorErrors(snap, collector.CPU, collector.Memory, collector.Load),
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(out)
@@ -283,25 +234,16 @@ type Listener struct {
 	Process      string `json:"process"`
 }
 
-type IFace struct {
-	Name        string `json:"name"`
-	BytesRecv   uint64 `json:"bytes_recv"`
-	BytesSent   uint64 `json:"bytes_sent"`
-	PacketsRecv uint64 `json:"packets_recv"`
-	PacketsSent uint64 `json:"packets_sent"`
-	ErrIn       uint64 `json:"err_in"`
-	ErrOut      uint64 `json:"err_out"`
-}
-
 type NetworkDetail struct {
-	Listeners   []Listener `json:"listeners"`
-	Interfaces  []IFace    `json:"interfaces"`
-	Connections []Conn     `json:"connections"`
+	Listeners   []Listener              `json:"listeners"`
+	Interfaces  []sampler.InterfaceStat `json:"interfaces"`
+	Connections []Conn                  `json:"connections"`
+	Errors      map[string]string       `json:"errors,omitempty"`
 }
 
-func NetworkDetailHandler() http.HandlerFunc {
+func NetworkDetailHandler(hub *sampler.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		conns, _ := gnet.Connections("inet")
+		conns, err := gnet.ConnectionsWithContext(r.Context(), "inet")
 		listeners := make([]Listener, 0)
 		established := make([]Conn, 0)
 		for _, c := range conns {
@@ -354,20 +296,21 @@ func NetworkDetailHandler() http.HandlerFunc {
 				}
 			}
 		}
-		ifaceStats, _ := gnet.IOCounters(true)
-		ifs := make([]IFace, 0, len(ifaceStats))
-		for _, s := range ifaceStats {
-			ifs = append(ifs, IFace{
-				Name:        s.Name,
-				BytesRecv:   s.BytesRecv,
-				BytesSent:   s.BytesSent,
-				PacketsRecv: s.PacketsRecv,
-				PacketsSent: s.PacketsSent,
-				ErrIn:       s.Errin,
-				ErrOut:      s.Errout,
-			})
+		out := NetworkDetail{Listeners: listeners, Interfaces: []sampler.InterfaceStat{}, Connections: established}
+		if err != nil {
+			out.Errors = map[string]string{"connections": err.Error()}
+		}
+		if snap := hub.Latest(); snap != nil {
+			if snap.Interfaces != nil {
+				out.Interfaces = snap.Interfaces
+			}
+			if e, ok := snap.Metrics.Errors[collector.Network]; ok {
+				if out.Errors == nil {
+					out.Errors = map[string]string{}
+				}
+				out.Errors[collector.Network] = e
+			}
 		}
-		out := NetworkDetail{Listeners: listeners, Interfaces: ifs, Connections: established}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(out)
 	}
@@ -383,3 +326,10 @@ type Conn struct {
 	Process   string `json:"process"`
 	Status    string `json:"status"`
 }
+
+func CollectorsHandler(reg *collector.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reg.Statuses())
+	}
+}
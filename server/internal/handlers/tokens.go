@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/auth"
+	"github.com/gofyr/server_monitor/server/internal/middleware"
+	"github.com/gofyr/server_monitor/server/internal/tokens"
+	"github.com/gorilla/mux"
+)
+
+// Tokens created without an expiry last this long.
+const defaultTokenTTL = 90 * 24 * time.Hour
+
+type tokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Either a duration such as "720h" or an absolute time
+	ExpiresIn string     `json:"expires_in"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type tokenCreated struct {
+	tokens.Token
+	// Only returned here
+	Secret string `json:"secret"`
+}
+
+// TokensHandler lists the caller's API tokens, or everyone's for admins.
+func TokensHandler(apiTokens *tokens.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := middleware.UsernameFromContext(r)
+		if auth.Allows(middleware.RoleFromContext(r), auth.RoleAdmin) {
+			owner = ""
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(apiTokens.List(owner))
+	}
+}
+
+// CreateTokenHandler issues an API token for the caller. The secret is
+// only part of this response. Tokens can only be created after logging in,
+// so an API token cannot mint one with wider scopes.
+func CreateTokenHandler(apiTokens *tokens.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if middleware.SessionFromContext(r) == "" {
+			http.Error(w, "API tokens cannot create tokens", http.StatusForbidden)
+			return
+		}
+		var req tokenRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		ttl := defaultTokenTTL
+		switch {
+		case req.ExpiresAt != nil:
+			ttl = time.Until(*req.ExpiresAt)
+		case req.ExpiresIn != "":
+			d, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil {
+				http.Error(w, "invalid expires_in", http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+		t, secret, err := apiTokens.Create(middleware.UsernameFromContext(r), req.Name, req.Scopes, ttl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(tokenCreated{Token: t, Secret: secret})
+	}
+}
+
+// RevokeTokenHandler deletes one of the caller's tokens; admins can revoke
+// anyone's.
+func RevokeTokenHandler(apiTokens *tokens.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := middleware.UsernameFromContext(r)
+		if auth.Allows(middleware.RoleFromContext(r), auth.RoleAdmin) {
+			owner = ""
+		}
+		switch err := apiTokens.Revoke(mux.Vars(r)["id"], owner); err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case tokens.ErrNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case tokens.ErrForbidden:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "revoke failed", http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gofyr/server_monitor/server/internal/sessions"
+	"github.com/gofyr/server_monitor/server/internal/tokens"
+	"github.com/gofyr/server_monitor/server/internal/users"
+	"github.com/gorilla/mux"
+)
+
+type userRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+func UsersHandler(accounts *users.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(accounts.List())
+	}
+}
+
+func CreateUserHandler(accounts *users.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req userRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		u, err := accounts.Add(req.Username, req.Password, req.Role)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(u)
+	}
+}
+
+// UpdateUserHandler changes the password and/or role of an account.
+func UpdateUserHandler(accounts *users.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req userRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		u, err := accounts.Update(mux.Vars(r)["username"], req.Password, req.Role)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u)
+	}
+}
+
+// DeleteUserHandler deletes an account along with its sessions and API
+// tokens.
+func DeleteUserHandler(accounts *users.Store, sess *sessions.Store, apiTokens *tokens.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := mux.Vars(r)["username"]
+		if err := accounts.Delete(username); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		if err := sess.RevokeUser(username); err != nil {
+			http.Error(w, "failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+		if err := apiTokens.RevokeUser(username); err != nil {
+			http.Error(w, "failed to revoke API tokens", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeUserError(w http.ResponseWriter, err error) {
+	switch err {
+	case users.ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case users.ErrExists, users.ErrConfigured:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
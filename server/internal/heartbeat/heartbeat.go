@@ -0,0 +1,358 @@
+// Package heartbeat implements dead man's switches: jobs ping the server
+// when they run, and a heartbeat that stops pinging or reports a failure
+// turns into an alert through the heartbeat_status series.
+package heartbeat
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/config"
+	"github.com/gofyr/server_monitor/server/internal/series"
+)
+
+// Values of the heartbeat_status series
+const (
+	statusUp     = 0
+	statusLate   = 1
+	statusFailed = 2
+)
+
+// Ping kinds
+const (
+	PingSuccess = "success"
+	PingStart   = "start"
+	PingFail    = "fail"
+)
+
+var (
+	ErrNotFound     = errors.New("heartbeat not found")
+	ErrUnauthorized = errors.New("invalid heartbeat token")
+	ErrExists       = errors.New("heartbeat already exists")
+	ErrConfigured   = errors.New("heartbeat is defined in the config")
+)
+
+var idPattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// Heartbeat is the public view of a heartbeat and its last pings.
+type Heartbeat struct {
+	ID            string  `json:"id"`
+	Source        string  `json:"source"` // config or api
+	PeriodSeconds float64 `json:"period_seconds"`
+	GraceSeconds  float64 `json:"grace_seconds"`
+	// How long a started run may take before it is late
+	MaxRuntimeSeconds float64    `json:"max_runtime_seconds"`
+	Status            string     `json:"status"` // new, up, running, late or failed
+	LastPing          *time.Time `json:"last_ping,omitempty"`
+	LastResult        string     `json:"last_result,omitempty"`
+	LastStart         *time.Time `json:"last_start,omitempty"`
+	// Reported by the job or measured from its start ping
+	LastDurationSeconds *float64   `json:"last_duration_seconds,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	NextDue             *time.Time `json:"next_due,omitempty"`
+}
+
+type beat struct {
+	ID     string        `json:"id"`
+	Period time.Duration `json:"period"`
+	Grace  time.Duration `json:"grace"`
+	// Zero means the period
+	MaxRuntime time.Duration `json:"max_runtime,omitempty"`
+	// SHA-256 of generated tokens; config heartbeats keep theirs in cfg
+	TokenSHA256 string    `json:"token_sha256,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	LastPing     *time.Time `json:"last_ping,omitempty"`
+	LastResult   string     `json:"last_result,omitempty"`
+	LastStart    *time.Time `json:"last_start,omitempty"`
+	LastDuration *float64   `json:"last_duration,omitempty"`
+
+	cfg *config.HeartbeatConfig
+}
+
+func (b *beat) maxRuntime() time.Duration {
+	if b.MaxRuntime > 0 {
+		return b.MaxRuntime
+	}
+	return b.Period
+}
+
+// status returns the state name and heartbeat_status value at now. A run
+// is late once it takes longer than the max runtime, and a heartbeat that
+// never pinged once period plus grace have passed since it was created;
+// until then new heartbeats have no value.
+func (b *beat) status(now time.Time) (string, int, bool) {
+	if b.LastStart != nil && (b.LastPing == nil || b.LastStart.After(*b.LastPing)) {
+		if now.Sub(*b.LastStart) > b.maxRuntime() {
+			return "late", statusLate, true
+		}
+		return "running", statusUp, true
+	}
+	if b.LastPing == nil {
+		if now.Sub(b.CreatedAt) > b.Period+b.Grace {
+			return "late", statusLate, true
+		}
+		return "new", 0, false
+	}
+	if b.LastResult == PingFail {
+		return "failed", statusFailed, true
+	}
+	if now.Sub(*b.LastPing) > b.Period+b.Grace {
+		return "late", statusLate, true
+	}
+	return "up", statusUp, true
+}
+
+func (b *beat) view(now time.Time) Heartbeat {
+	st, _, _ := b.status(now)
+	h := Heartbeat{
+		ID:                  b.ID,
+		Source:              "api",
+		PeriodSeconds:       b.Period.Seconds(),
+		GraceSeconds:        b.Grace.Seconds(),
+		MaxRuntimeSeconds:   b.maxRuntime().Seconds(),
+		Status:              st,
+		LastPing:            b.LastPing,
+		LastResult:          b.LastResult,
+		LastStart:           b.LastStart,
+		LastDurationSeconds: b.LastDuration,
+		CreatedAt:           b.CreatedAt,
+	}
+	if b.cfg != nil {
+		h.Source = "config"
+	}
+	due := b.CreatedAt.Add(b.Period)
+	if b.LastPing != nil {
+		due = b.LastPing.Add(b.Period)
+	}
+	h.NextDue = &due
+	return h
+}
+
+func (b *beat) checkToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	if b.cfg != nil {
+		if b.cfg.TokenHash != "" && config.CheckPassword(b.cfg.TokenHash, token) {
+			return true
+		}
+		return b.cfg.Token != "" && subtle.ConstantTimeCompare([]byte(b.cfg.Token), []byte(token)) == 1
+	}
+	sum := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(b.TokenSHA256)) == 1
+}
+
+// Monitor holds all heartbeats. Heartbeats created through the API and the
+// last pings of all heartbeats are saved as JSON at path.
+type Monitor struct {
+	path  string
+	mu    sync.Mutex
+	beats map[string]*beat
+}
+
+func NewMonitor(path string, cfgs []config.HeartbeatConfig) (*Monitor, error) {
+	m := &Monitor{path: path, beats: map[string]*beat{}}
+	var saved []*beat
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &saved); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	byID := map[string]*beat{}
+	for _, s := range saved {
+		byID[s.ID] = s
+	}
+	added := false
+	for i := range cfgs {
+		c := &cfgs[i]
+		if !idPattern.MatchString(c.ID) {
+			return nil, fmt.Errorf("heartbeat: invalid id %q", c.ID)
+		}
+		if c.Period <= 0 {
+			return nil, fmt.Errorf("heartbeat %q: period required", c.ID)
+		}
+		if c.Grace < 0 || c.MaxRuntime < 0 {
+			return nil, fmt.Errorf("heartbeat %q: grace and max_runtime must not be negative", c.ID)
+		}
+		if c.Token == "" && c.TokenHash == "" {
+			return nil, fmt.Errorf("heartbeat %q: token or token_hash required", c.ID)
+		}
+		if _, dup := m.beats[c.ID]; dup {
+			return nil, fmt.Errorf("heartbeat: duplicate id %q", c.ID)
+		}
+		nb := &beat{ID: c.ID, Period: c.Period, Grace: c.Grace, MaxRuntime: c.MaxRuntime, CreatedAt: time.Now(), cfg: c}
+		if s := byID[c.ID]; s != nil {
+			nb.CreatedAt, nb.LastPing, nb.LastResult, nb.LastStart, nb.LastDuration = s.CreatedAt, s.LastPing, s.LastResult, s.LastStart, s.LastDuration
+		} else {
+			// Keep the creation time across restarts, as a heartbeat that
+			// never pings becomes late relative to it
+			added = true
+		}
+		m.beats[c.ID] = nb
+	}
+	for _, s := range saved {
+		if _, ok := m.beats[s.ID]; !ok && s.TokenSHA256 != "" {
+			m.beats[s.ID] = s
+		}
+	}
+	if added {
+		m.save()
+	}
+	return m, nil
+}
+
+// Create adds a heartbeat and returns its token, which is not stored. A
+// zero maxRuntime allows started runs to take up to the period.
+func (m *Monitor) Create(id string, period, grace, maxRuntime time.Duration) (Heartbeat, string, error) {
+	if !idPattern.MatchString(id) {
+		return Heartbeat{}, "", errors.New("id must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	if period <= 0 || grace < 0 || maxRuntime < 0 {
+		return Heartbeat{}, "", errors.New("period must be positive and grace and max_runtime not negative")
+	}
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return Heartbeat{}, "", err
+	}
+	token := hex.EncodeToString(raw)
+	sum := sha256.Sum256([]byte(token))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.beats[id]; ok {
+		return Heartbeat{}, "", ErrExists
+	}
+	b := &beat{ID: id, Period: period, Grace: grace, MaxRuntime: maxRuntime, TokenSHA256: hex.EncodeToString(sum[:]), CreatedAt: time.Now()}
+	m.beats[id] = b
+	m.save()
+	return b.view(time.Now()), token, nil
+}
+
+// Delete removes a heartbeat created through the API.
+func (m *Monitor) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.beats[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if b.cfg != nil {
+		return ErrConfigured
+	}
+	delete(m.beats, id)
+	m.save()
+	return nil
+}
+
+// Ping records a ping of the given kind. duration, when positive, is the
+// job's own measurement of its run time; otherwise a success or failure
+// after a start ping is timed from the start.
+func (m *Monitor) Ping(id, token, kind string, duration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.beats[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if !b.checkToken(token) {
+		return ErrUnauthorized
+	}
+	now := time.Now()
+	if kind == PingStart {
+		b.LastStart = &now
+		m.save()
+		return nil
+	}
+	if duration <= 0 && b.LastStart != nil && (b.LastPing == nil || b.LastStart.After(*b.LastPing)) {
+		duration = now.Sub(*b.LastStart)
+	}
+	if duration > 0 {
+		secs := duration.Seconds()
+		b.LastDuration = &secs
+	}
+	b.LastPing = &now
+	b.LastResult = kind
+	m.save()
+	return nil
+}
+
+// List returns all heartbeats sorted by ID.
+func (m *Monitor) List() []Heartbeat {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Heartbeat, 0, len(m.beats))
+	for _, b := range m.beats {
+		out = append(out, b.view(now))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Series reports heartbeat_status (0 up, 1 late, 2 failed) per heartbeat
+// that has pinged or is overdue for its first ping, and the last run
+// duration.
+func (m *Monitor) Series() map[string]float64 {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := map[string]float64{}
+	for _, b := range m.beats {
+		labels := map[string]string{"heartbeat": b.ID}
+		if _, v, ok := b.status(now); ok {
+			out[series.Key("heartbeat_status", labels)] = float64(v)
+		}
+		if b.LastDuration != nil {
+			out[series.Key("heartbeat_duration_seconds", labels)] = *b.LastDuration
+		}
+	}
+	return out
+}
+
+// OwnedNames reserves the heartbeat series names for the monitor.
+func (m *Monitor) OwnedNames() []string {
+	return []string{"heartbeat_status", "heartbeat_duration_seconds"}
+}
+
+// save writes the heartbeats to disk. Callers hold m.mu.
+func (m *Monitor) save() {
+	list := make([]*beat, 0, len(m.beats))
+	for _, b := range m.beats {
+		list = append(list, b)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return
+	}
+	tmp := m.path + ".tmp"
+	if os.WriteFile(tmp, data, 0o600) == nil {
+		os.Rename(tmp, m.path)
+	}
+}
+
+// AlertRule is the built-in rule that turns late and failed heartbeats
+// into alerts. A configured rule with the same name replaces it.
+func AlertRule() config.AlertRule {
+	return config.AlertRule{
+		Name:     "heartbeat",
+		Expr:     "heartbeat_status > 0",
+		Severity: "critical",
+		Annotations: map[string]string{
+			"summary": `Heartbeat {{ .Labels.heartbeat }} {{ if eq .Value 2.0 }}reported a failure{{ else }}is late{{ end }}`,
+		},
+	}
+}
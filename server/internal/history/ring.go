@@ -0,0 +1,87 @@
+package history
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/sampler"
+)
+
+// Ring keeps the most recent snapshots in a fixed-size circular buffer.
+type Ring struct {
+	mu    sync.RWMutex
+	buf   []*sampler.Snapshot
+	start int
+	n     int
+}
+
+func NewRing(capacity int) *Ring {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Ring{buf: make([]*sampler.Snapshot, capacity)}
+}
+
+// Add appends s, overwriting the oldest snapshot when the buffer is full.
+func (r *Ring) Add(s *sampler.Snapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = s
+		r.n++
+		return
+	}
+	r.buf[r.start] = s
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// Oldest returns the time of the oldest retained snapshot.
+func (r *Ring) Oldest() (time.Time, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.n == 0 {
+		return time.Time{}, false
+	}
+	return r.buf[r.start].Time, true
+}
+
+// Range returns snapshots with from <= Time <= to in chronological order.
+// When step is positive only the last snapshot of each step-sized bucket
+// is kept.
+func (r *Ring) Range(from, to time.Time, step time.Duration) []*sampler.Snapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]*sampler.Snapshot, 0)
+	var bucket int64 = -1
+	for i := 0; i < r.n; i++ {
+		s := r.buf[(r.start+i)%len(r.buf)]
+		if s.Time.Before(from) || s.Time.After(to) {
+			continue
+		}
+		if step > 0 {
+			b := int64(s.Time.Sub(from) / step)
+			if b == bucket {
+				out[len(out)-1] = s
+				continue
+			}
+			bucket = b
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Run records every snapshot published on hub until ctx is cancelled.
+func (r *Ring) Run(ctx context.Context, hub *sampler.Hub) {
+	ch, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-ch:
+			r.Add(s)
+		}
+	}
+}
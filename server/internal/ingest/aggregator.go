@@ -0,0 +1,277 @@
+// Package ingest accepts application metrics pushed over HTTP or StatsD and
+// aggregates them per flush interval into series for the sampler.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/series"
+)
+
+type Kind int
+
+const (
+	Gauge Kind = iota
+	Counter
+	Timer
+	Set
+)
+
+// A sample is one observation before aggregation.
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Kind   Kind
+	Value  float64
+	// Gauge only: Value is added to the current gauge instead of replacing it
+	Delta bool
+	// Set only: the member observed
+	Member string
+}
+
+var ErrTooManySeries = errors.New("ingest: series limit reached")
+
+// Series not updated for this many flush intervals are dropped.
+const staleFlushes = 10
+
+type pending struct {
+	kind    Kind
+	gauge   float64
+	sum     float64
+	count   float64
+	min     float64
+	max     float64
+	members map[string]struct{}
+	seen    time.Time
+}
+
+// Aggregator collects samples and turns them into series once per flush
+// interval. Gauges report their last value; counters report the count in
+// the interval as name and its per-second rate as name_rate; timers report
+// name_mean, name_min, name_max and name_count; sets report the number of
+// distinct members.
+type Aggregator struct {
+	interval  time.Duration
+	maxSeries int
+	reserved  func(name string) bool
+
+	mu      sync.Mutex
+	pending map[string]*pending
+	flushed map[string]float64
+	// The pending key producing each series on flush
+	emitters map[string]string
+}
+
+func NewAggregator(interval time.Duration, maxSeries int) *Aggregator {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &Aggregator{interval: interval, maxSeries: maxSeries, pending: map[string]*pending{}, flushed: map[string]float64{}, emitters: map[string]string{}}
+}
+
+// Reserve rejects samples whose series names reserved reports true for,
+// such as built-in metrics or check results.
+func (a *Aggregator) Reserve(reserved func(name string) bool) {
+	a.reserved = reserved
+}
+
+// seriesNames returns the names a metric of kind produces on flush.
+func seriesNames(kind Kind, name string) []string {
+	switch kind {
+	case Counter:
+		return []string{name, name + "_rate"}
+	case Timer:
+		return []string{name + "_count", name + "_mean", name + "_min", name + "_max"}
+	default:
+		return []string{name}
+	}
+}
+
+// Add records samples. Either all samples are accepted or, if one is
+// invalid or would exceed the series limit, none are.
+func (a *Aggregator) Add(samples []Sample) error {
+	for i := range samples {
+		name, err := sanitizeName(samples[i].Name)
+		if err != nil {
+			return err
+		}
+		samples[i].Name = name
+		if len(samples[i].Labels) > 0 {
+			labels := make(map[string]string, len(samples[i].Labels))
+			for k, v := range samples[i].Labels {
+				lk, err := sanitizeName(k)
+				if err != nil {
+					return err
+				}
+				labels[lk] = v
+			}
+			samples[i].Labels = labels
+		}
+		if math.IsNaN(samples[i].Value) || math.IsInf(samples[i].Value, 0) {
+			return fmt.Errorf("ingest: %s: value must be finite", name)
+		}
+		if a.reserved != nil {
+			for _, n := range seriesNames(samples[i].Kind, name) {
+				if a.reserved(n) {
+					return fmt.Errorf("ingest: %s is a reserved metric name", n)
+				}
+			}
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Series that new keys would produce, checked against those of other
+	// keys so that, say, a gauge foo_rate cannot overwrite the rate of a
+	// counter foo
+	added := map[string]string{}
+	for _, s := range samples {
+		key := series.Key(s.Name, s.Labels)
+		if p, ok := a.pending[key]; ok {
+			if p.kind != s.Kind {
+				return fmt.Errorf("ingest: %s already has a different type", key)
+			}
+			continue
+		}
+		for _, n := range seriesNames(s.Kind, s.Name) {
+			sk := series.Key(n, s.Labels)
+			owner, ok := a.emitters[sk]
+			if !ok {
+				owner, ok = added[sk]
+			}
+			if ok && owner != key {
+				return fmt.Errorf("ingest: %s and %s would both produce %s", key, owner, sk)
+			}
+			added[sk] = key
+		}
+	}
+	if a.maxSeries > 0 && len(a.emitters)+len(added) > a.maxSeries {
+		return ErrTooManySeries
+	}
+	for sk, key := range added {
+		a.emitters[sk] = key
+	}
+	now := time.Now()
+	for _, s := range samples {
+		key := series.Key(s.Name, s.Labels)
+		p, ok := a.pending[key]
+		if !ok {
+			p = &pending{kind: s.Kind}
+			a.pending[key] = p
+		}
+		p.seen = now
+		switch s.Kind {
+		case Gauge:
+			if s.Delta {
+				p.gauge += s.Value
+			} else {
+				p.gauge = s.Value
+			}
+		case Counter:
+			p.sum += s.Value
+		case Timer:
+			if p.count == 0 || s.Value < p.min {
+				p.min = s.Value
+			}
+			if p.count == 0 || s.Value > p.max {
+				p.max = s.Value
+			}
+			p.sum += s.Value
+			p.count++
+		case Set:
+			if p.members == nil {
+				p.members = map[string]struct{}{}
+			}
+			p.members[s.Member] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// Flush aggregates the current interval and resets interval-scoped state.
+func (a *Aggregator) Flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	out := make(map[string]float64, len(a.pending))
+	for key, p := range a.pending {
+		name, labels, _ := series.Parse(key)
+		if now.Sub(p.seen) > staleFlushes*a.interval {
+			delete(a.pending, key)
+			for _, n := range seriesNames(p.kind, name) {
+				delete(a.emitters, series.Key(n, labels))
+			}
+			continue
+		}
+		switch p.kind {
+		case Gauge:
+			out[key] = p.gauge
+		case Counter:
+			out[key] = p.sum
+			out[series.Key(name+"_rate", labels)] = p.sum / a.interval.Seconds()
+			p.sum = 0
+		case Timer:
+			out[series.Key(name+"_count", labels)] = p.count
+			if p.count > 0 {
+				out[series.Key(name+"_mean", labels)] = p.sum / p.count
+				out[series.Key(name+"_min", labels)] = p.min
+				out[series.Key(name+"_max", labels)] = p.max
+			}
+			p.sum, p.count = 0, 0
+		case Set:
+			out[key] = float64(len(p.members))
+			p.members = nil
+		}
+	}
+	a.flushed = out
+}
+
+// Series returns the values of the last flush.
+func (a *Aggregator) Series() map[string]float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.flushed
+}
+
+// Run flushes once per interval until ctx is cancelled.
+func (a *Aggregator) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Flush()
+		}
+	}
+}
+
+// sanitizeName maps a metric name onto [a-zA-Z0-9_:], turning the dots
+// and dashes common in StatsD names into underscores.
+func sanitizeName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("ingest: metric name required")
+	}
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String(), nil
+}
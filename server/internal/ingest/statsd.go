@@ -0,0 +1,101 @@
+package ingest
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ListenStatsD receives StatsD datagrams on addr until ctx is cancelled.
+// Supported types are c, g, ms, h, d and s, with optional @rate sampling
+// and DogStatsD-style #key:value tags as labels.
+func ListenStatsD(ctx context.Context, addr string, agg *Aggregator) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("statsd: read: %v", err)
+				}
+				return
+			}
+			for _, line := range strings.Split(string(buf[:n]), "\n") {
+				s, ok := parseStatsD(line)
+				if !ok {
+					continue
+				}
+				if err := agg.Add([]Sample{s}); err != nil {
+					log.Printf("statsd: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// parseStatsD parses name:value|type[|@rate][|#tags].
+func parseStatsD(line string) (Sample, bool) {
+	line = strings.TrimSpace(line)
+	colon := strings.IndexByte(line, ':')
+	if colon <= 0 {
+		return Sample{}, false
+	}
+	fields := strings.Split(line[colon+1:], "|")
+	if len(fields) < 2 {
+		return Sample{}, false
+	}
+	s := Sample{Name: line[:colon]}
+	raw := fields[0]
+	rate := 1.0
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			if r, err := strconv.ParseFloat(f[1:], 64); err == nil && r > 0 && r <= 1 {
+				rate = r
+			}
+		case strings.HasPrefix(f, "#"):
+			s.Labels = map[string]string{}
+			for _, tag := range strings.Split(f[1:], ",") {
+				k, v, _ := strings.Cut(tag, ":")
+				if k != "" {
+					s.Labels[k] = v
+				}
+			}
+		}
+	}
+	switch fields[1] {
+	case "c":
+		s.Kind = Counter
+	case "g":
+		s.Kind = Gauge
+		s.Delta = strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-")
+	case "ms", "h", "d":
+		s.Kind = Timer
+	case "s":
+		s.Kind = Set
+		s.Member = raw
+		return s, true
+	default:
+		return Sample{}, false
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return Sample{}, false
+	}
+	if s.Kind == Counter {
+		v /= rate
+	}
+	s.Value = v
+	return s, true
+}
@@ -18,11 +18,7 @@ func CIDRAllowlist(cidrs []string) func(http.Handler) http.Handler {
 	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			host := r.RemoteAddr
-			if idx := strings.LastIndex(host, ":"); idx >= 0 {
-				host = host[:idx]
-			}
-			ip := net.ParseIP(host)
+			ip := net.ParseIP(ClientIP(r))
 			allowed := false
 			for _, n := range nets {
 				if n.Contains(ip) {
@@ -38,3 +34,12 @@ func CIDRAllowlist(cidrs []string) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// ClientIP returns the address of the connecting peer without the port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -6,13 +6,26 @@ import (
 	"strings"
 
 	"github.com/gofyr/server_monitor/server/internal/auth"
+	"github.com/gofyr/server_monitor/server/internal/sessions"
+	"github.com/gofyr/server_monitor/server/internal/tokens"
+	"github.com/gofyr/server_monitor/server/internal/users"
 )
 
 type ctxKey string
 
-const userKey ctxKey = "user"
+const (
+	userKey    ctxKey = "user"
+	roleKey    ctxKey = "role"
+	sessionKey ctxKey = "session"
+)
 
-func JWTAuth(jwtManager *auth.JWTManager) func(http.Handler) http.Handler {
+// JWTAuth accepts a login access token of an active session or an API
+// token. Either acts with the current role of its account and fails once
+// the account is deleted, or when it predates the account, which means it
+// was issued to an earlier account of the same name. API tokens are further limited to the resources
+// their scopes grant: the resource is the first path segment after /api,
+// and any method but GET or HEAD needs write access.
+func JWTAuth(jwtManager *auth.JWTManager, sess *sessions.Store, apiTokens *tokens.Store, accounts *users.Store) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authz := r.Header.Get("Authorization")
@@ -21,12 +34,46 @@ func JWTAuth(jwtManager *auth.JWTManager) func(http.Handler) http.Handler {
 				return
 			}
 			token := strings.TrimSpace(authz[len("Bearer "):])
+			if strings.HasPrefix(token, tokens.Prefix) {
+				t, err := apiTokens.Authenticate(token)
+				if err != nil {
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+				owner, err := accounts.Get(t.Username)
+				if err != nil || owner.Predates(t.CreatedAt) {
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+				resource, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+				write := r.Method != http.MethodGet && r.Method != http.MethodHead
+				if !tokens.Allows(t.Scopes, resource, write) {
+					http.Error(w, "insufficient scope", http.StatusForbidden)
+					return
+				}
+				ctx := context.WithValue(r.Context(), userKey, owner.Username)
+				ctx = context.WithValue(ctx, roleKey, owner.Role)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
 			claims, err := jwtManager.Verify(token)
 			if err != nil || claims.TokenUse != "access" {
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
-			ctx := context.WithValue(r.Context(), userKey, claims.Username)
+			session, ok := sess.Touch(claims.SessionID, ClientIP(r))
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			account, err := accounts.Get(claims.Username)
+			if err != nil || account.Predates(session.CreatedAt) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			ctx := context.WithValue(r.Context(), userKey, account.Username)
+			ctx = context.WithValue(ctx, roleKey, account.Role)
+			ctx = context.WithValue(ctx, sessionKey, claims.SessionID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -37,3 +84,31 @@ func UsernameFromContext(r *http.Request) string {
 	s, _ := v.(string)
 	return s
 }
+
+// RequireRole rejects requests whose token does not grant at least min.
+// It must run after JWTAuth.
+func RequireRole(min string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !auth.Allows(RoleFromContext(r), min) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func RoleFromContext(r *http.Request) string {
+	v := r.Context().Value(roleKey)
+	s, _ := v.(string)
+	return s
+}
+
+// SessionFromContext returns the session ID of a login token, or empty for
+// API tokens.
+func SessionFromContext(r *http.Request) string {
+	v := r.Context().Value(sessionKey)
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gofyr/server_monitor/server/internal/config"
+)
+
+// ScrapeAuth guards machine endpoints such as /metrics that cannot log in.
+// A request passes with the configured static bearer token or, when
+// require_client_cert is set, with a verified client certificate.
+func ScrapeAuth(pc config.PrometheusConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if pc.RequireClientCert && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authz := r.Header.Get("Authorization")
+			if strings.HasPrefix(strings.ToLower(authz), "bearer ") {
+				token := strings.TrimSpace(authz[len("Bearer "):])
+				if pc.BearerTokenHash != "" && config.CheckPassword(pc.BearerTokenHash, token) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				if pc.BearerToken != "" && subtle.ConstantTimeCompare([]byte(pc.BearerToken), []byte(token)) == 1 {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"fmt"
+
+	"github.com/gofyr/server_monitor/server/internal/config"
+)
+
+// Channels builds the channels configured in cfg. Chat channels keep their
+// message references in refs.
+func Channels(cfg config.NotifyConfig, refs *Refs) ([]Channel, error) {
+	var out []Channel
+	for _, wc := range cfg.Webhooks {
+		if wc.Name == "" {
+			return nil, fmt.Errorf("notify: webhook name required")
+		}
+		w, err := newWebhook(wc)
+		if err != nil {
+			return nil, fmt.Errorf("notify: webhook %q: %w", wc.Name, err)
+		}
+		out = append(out, w)
+	}
+	for _, ec := range cfg.Email {
+		if ec.Name == "" {
+			return nil, fmt.Errorf("notify: email name required")
+		}
+		m, err := newEmail(ec)
+		if err != nil {
+			return nil, fmt.Errorf("notify: email %q: %w", ec.Name, err)
+		}
+		out = append(out, m)
+	}
+	for _, nc := range cfg.Ntfy {
+		if nc.Name == "" {
+			return nil, fmt.Errorf("notify: ntfy name required")
+		}
+		n, err := newNtfy(nc)
+		if err != nil {
+			return nil, fmt.Errorf("notify: ntfy %q: %w", nc.Name, err)
+		}
+		out = append(out, n)
+	}
+	for _, gc := range cfg.Gotify {
+		if gc.Name == "" {
+			return nil, fmt.Errorf("notify: gotify name required")
+		}
+		g, err := newGotify(gc)
+		if err != nil {
+			return nil, fmt.Errorf("notify: gotify %q: %w", gc.Name, err)
+		}
+		out = append(out, g)
+	}
+	for _, uc := range cfg.UnifiedPush {
+		if uc.Name == "" {
+			return nil, fmt.Errorf("notify: unifiedpush name required")
+		}
+		u, err := newUnifiedPush(uc)
+		if err != nil {
+			return nil, fmt.Errorf("notify: unifiedpush %q: %w", uc.Name, err)
+		}
+		out = append(out, u)
+	}
+	for _, sc := range cfg.Slack {
+		if sc.Name == "" {
+			return nil, fmt.Errorf("notify: slack name required")
+		}
+		c, err := newSlack(sc, refs)
+		if err != nil {
+			return nil, fmt.Errorf("notify: slack %q: %w", sc.Name, err)
+		}
+		out = append(out, c)
+	}
+	for _, dc := range cfg.Discord {
+		if dc.Name == "" {
+			return nil, fmt.Errorf("notify: discord name required")
+		}
+		c, err := newDiscord(dc, refs)
+		if err != nil {
+			return nil, fmt.Errorf("notify: discord %q: %w", dc.Name, err)
+		}
+		out = append(out, c)
+	}
+	for _, mc := range cfg.Matrix {
+		if mc.Name == "" {
+			return nil, fmt.Errorf("notify: matrix name required")
+		}
+		c, err := newMatrix(mc, refs)
+		if err != nil {
+			return nil, fmt.Errorf("notify: matrix %q: %w", mc.Name, err)
+		}
+		out = append(out, c)
+	}
+	for _, tc := range cfg.Telegram {
+		if tc.Name == "" {
+			return nil, fmt.Errorf("notify: telegram name required")
+		}
+		c, err := newTelegram(tc, refs)
+		if err != nil {
+			return nil, fmt.Errorf("notify: telegram %q: %w", tc.Name, err)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
@@ -0,0 +1,310 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/config"
+)
+
+// Embed and attachment colours
+const (
+	colorFiring   = 0xC62828
+	colorWarning  = 0xEF6C00
+	colorResolved = 0x2E7D32
+)
+
+func color(msg *Message) int {
+	switch {
+	case msg.Type == "resolved":
+		return colorResolved
+	case msg.Alert.Severity == "critical":
+		return colorFiring
+	default:
+		return colorWarning
+	}
+}
+
+func postJSON(ctx context.Context, client *http.Client, method, url string, payload, out any, header http.Header) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Permanent(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doJSON(client, req, out)
+}
+
+// redact replaces secret in err's text, keeping err permanent if it was.
+func redact(err error, secret string) error {
+	if !strings.Contains(err.Error(), secret) {
+		return err
+	}
+	clean := errors.New(strings.ReplaceAll(err.Error(), secret, "REDACTED"))
+	var perm permanentError
+	if errors.As(err, &perm) {
+		return Permanent(clean)
+	}
+	return clean
+}
+
+// detailLine is the series, value and severity in one line.
+func detailLine(msg *Message) string {
+	return fmt.Sprintf("%s = %g · %s", msg.Alert.Series, msg.Alert.Value, msg.Alert.Severity)
+}
+
+// slack posts Block Kit messages either to an incoming webhook (also
+// accepted by Mattermost and Rocket.Chat) or, with a bot token, through
+// chat.postMessage so resolutions can be threaded.
+type slack struct {
+	cfg    config.SlackConfig
+	refs   *Refs
+	client *http.Client
+}
+
+func newSlack(cfg config.SlackConfig, refs *Refs) (*slack, error) {
+	if cfg.WebhookURL == "" && (cfg.Token == "" || cfg.Channel == "") {
+		return nil, errors.New("webhook_url or token and channel required")
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = "https://slack.com/api"
+	}
+	return &slack{cfg: cfg, refs: refs, client: &http.Client{}}, nil
+}
+
+func (s *slack) Name() string { return s.cfg.Name }
+
+func (s *slack) Send(ctx context.Context, msg *Message) error {
+	payload := map[string]any{
+		"text": msg.Title() + ": " + msg.Summary(),
+		"blocks": []any{
+			map[string]any{"type": "section", "text": map[string]string{
+				"type": "mrkdwn", "text": "*" + slackEscape(msg.Title()) + "*\n" + slackEscape(msg.Summary()),
+			}},
+			map[string]any{"type": "context", "elements": []any{
+				map[string]string{"type": "mrkdwn", "text": "`" + slackEscape(msg.Alert.Series) + "` = " + strconv.FormatFloat(msg.Alert.Value, 'g', -1, 64) + " · " + msg.Alert.Severity},
+			}},
+		},
+	}
+	if s.cfg.Token == "" {
+		return postJSON(ctx, s.client, http.MethodPost, s.cfg.WebhookURL, payload, nil, nil)
+	}
+	payload["channel"] = s.cfg.Channel
+	if msg.Type == "resolved" {
+		if ts := s.refs.get(s.cfg.Name, msg.Alert.ID); ts != "" {
+			payload["thread_ts"] = ts
+		}
+	}
+	var resp struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		TS    string `json:"ts"`
+	}
+	header := http.Header{"Authorization": {"Bearer " + s.cfg.Token}}
+	if err := postJSON(ctx, s.client, http.MethodPost, strings.TrimSuffix(s.cfg.APIURL, "/")+"/chat.postMessage", payload, &resp, header); err != nil {
+		return err
+	}
+	if !resp.OK {
+		if resp.Error == "ratelimited" {
+			return errors.New(resp.Error)
+		}
+		return Permanent(fmt.Errorf("slack: %s", resp.Error))
+	}
+	if msg.Type == "firing" {
+		s.refs.put(s.cfg.Name, msg.Alert.ID, resp.TS)
+	} else {
+		s.refs.remove(s.cfg.Name, msg.Alert.ID)
+	}
+	return nil
+}
+
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// discord posts embeds through a webhook and edits the original message
+// when the alert resolves.
+type discord struct {
+	cfg    config.DiscordConfig
+	refs   *Refs
+	client *http.Client
+}
+
+func newDiscord(cfg config.DiscordConfig, refs *Refs) (*discord, error) {
+	if cfg.WebhookURL == "" {
+		return nil, errors.New("webhook_url required")
+	}
+	if _, err := url.Parse(cfg.WebhookURL); err != nil {
+		return nil, err
+	}
+	return &discord{cfg: cfg, refs: refs, client: &http.Client{}}, nil
+}
+
+func (d *discord) Name() string { return d.cfg.Name }
+
+func (d *discord) Send(ctx context.Context, msg *Message) error {
+	payload := map[string]any{
+		"embeds": []any{map[string]any{
+			"title":       msg.Title(),
+			"description": msg.Summary(),
+			"color":       color(msg),
+			"timestamp":   msg.Time.Format(time.RFC3339),
+			"fields": []any{
+				map[string]any{"name": "Series", "value": "`" + msg.Alert.Series + "`", "inline": true},
+				map[string]any{"name": "Value", "value": strconv.FormatFloat(msg.Alert.Value, 'g', -1, 64), "inline": true},
+				map[string]any{"name": "Severity", "value": msg.Alert.Severity, "inline": true},
+			},
+		}},
+	}
+	u, _ := url.Parse(d.cfg.WebhookURL)
+	if msg.Type == "resolved" {
+		if id := d.refs.get(d.cfg.Name, msg.Alert.ID); id != "" {
+			u.Path = strings.TrimSuffix(u.Path, "/") + "/messages/" + id
+			err := postJSON(ctx, d.client, http.MethodPatch, u.String(), payload, nil, nil)
+			if err == nil {
+				d.refs.remove(d.cfg.Name, msg.Alert.ID)
+			}
+			return err
+		}
+	}
+	q := u.Query()
+	q.Set("wait", "true")
+	u.RawQuery = q.Encode()
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := postJSON(ctx, d.client, http.MethodPost, u.String(), payload, &resp, nil); err != nil {
+		return err
+	}
+	if msg.Type == "firing" && resp.ID != "" {
+		d.refs.put(d.cfg.Name, msg.Alert.ID, resp.ID)
+	}
+	return nil
+}
+
+// matrix sends HTML messages to a room and threads resolutions onto the
+// firing message.
+type matrix struct {
+	cfg    config.MatrixConfig
+	refs   *Refs
+	client *http.Client
+}
+
+func newMatrix(cfg config.MatrixConfig, refs *Refs) (*matrix, error) {
+	if cfg.Homeserver == "" || cfg.AccessToken == "" || cfg.RoomID == "" {
+		return nil, errors.New("homeserver, access_token and room_id required")
+	}
+	return &matrix{cfg: cfg, refs: refs, client: &http.Client{}}, nil
+}
+
+func (m *matrix) Name() string { return m.cfg.Name }
+
+func (m *matrix) Send(ctx context.Context, msg *Message) error {
+	content := map[string]any{
+		"msgtype": "m.text",
+		"body":    msg.Title() + "\n" + msg.Summary() + "\n" + detailLine(msg),
+		"format":  "org.matrix.custom.html",
+		"formatted_body": fmt.Sprintf(`<strong><font color="#%06X">%s</font></strong><br>%s<br><code>%s</code> = %g · %s`,
+			color(msg), html.EscapeString(msg.Title()), html.EscapeString(msg.Summary()),
+			html.EscapeString(msg.Alert.Series), msg.Alert.Value, html.EscapeString(msg.Alert.Severity)),
+	}
+	if msg.Type == "resolved" {
+		if root := m.refs.get(m.cfg.Name, msg.Alert.ID); root != "" {
+			content["m.relates_to"] = map[string]any{
+				"rel_type":        "m.thread",
+				"event_id":        root,
+				"is_falling_back": true,
+				"m.in_reply_to":   map[string]string{"event_id": root},
+			}
+		}
+	}
+	// The transaction ID makes retries idempotent on the homeserver
+	txn := msg.Alert.ID + "-" + msg.Type
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(m.cfg.Homeserver, "/"), url.PathEscape(m.cfg.RoomID), url.PathEscape(txn))
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+	header := http.Header{"Authorization": {"Bearer " + m.cfg.AccessToken}}
+	if err := postJSON(ctx, m.client, http.MethodPut, endpoint, content, &resp, header); err != nil {
+		return err
+	}
+	if msg.Type == "firing" {
+		m.refs.put(m.cfg.Name, msg.Alert.ID, resp.EventID)
+	} else {
+		m.refs.remove(m.cfg.Name, msg.Alert.ID)
+	}
+	return nil
+}
+
+// telegram sends HTML messages through the Bot API and posts resolutions
+// as replies to the firing message.
+type telegram struct {
+	cfg    config.TelegramConfig
+	refs   *Refs
+	client *http.Client
+}
+
+func newTelegram(cfg config.TelegramConfig, refs *Refs) (*telegram, error) {
+	if cfg.BotToken == "" || cfg.ChatID == "" {
+		return nil, errors.New("bot_token and chat_id required")
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = "https://api.telegram.org"
+	}
+	return &telegram{cfg: cfg, refs: refs, client: &http.Client{}}, nil
+}
+
+func (t *telegram) Name() string { return t.cfg.Name }
+
+func (t *telegram) Send(ctx context.Context, msg *Message) error {
+	icon := "🔥"
+	if msg.Type == "resolved" {
+		icon = "✅"
+	}
+	payload := map[string]any{
+		"chat_id":    t.cfg.ChatID,
+		"parse_mode": "HTML",
+		"text": fmt.Sprintf("%s <b>%s</b>\n%s\n<code>%s</code> = %g · %s", icon,
+			html.EscapeString(msg.Title()), html.EscapeString(msg.Summary()),
+			html.EscapeString(msg.Alert.Series), msg.Alert.Value, html.EscapeString(msg.Alert.Severity)),
+	}
+	if msg.Type == "resolved" {
+		if id := t.refs.get(t.cfg.Name, msg.Alert.ID); id != "" {
+			n, _ := strconv.Atoi(id)
+			payload["reply_parameters"] = map[string]any{"message_id": n, "allow_sending_without_reply": true}
+		}
+	}
+	var resp struct {
+		OK     bool `json:"ok"`
+		Result struct {
+			MessageID int `json:"message_id"`
+		} `json:"result"`
+	}
+	endpoint := strings.TrimSuffix(t.cfg.APIURL, "/") + "/bot" + t.cfg.BotToken + "/sendMessage"
+	if err := postJSON(ctx, t.client, http.MethodPost, endpoint, payload, &resp, nil); err != nil {
+		// Transport errors quote the URL, which holds the bot token
+		return redact(err, t.cfg.BotToken)
+	}
+	if msg.Type == "firing" {
+		t.refs.put(t.cfg.Name, msg.Alert.ID, strconv.Itoa(resp.Result.MessageID))
+	} else {
+		t.refs.remove(t.cfg.Name, msg.Alert.ID)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/config"
+)
+
+const defaultTextTemplate = `{{ range .Messages }}{{ .Title }}
+  {{ .Summary }}
+  series: {{ .Alert.Series }}  value: {{ .Alert.Value }}  severity: {{ .Alert.Severity }}
+  since: {{ .Alert.ActiveAt.Format "2006-01-02 15:04:05 MST" }}
+{{ range $k, $v := .Alert.Annotations }}{{ if ne $k "summary" }}  {{ $k }}: {{ $v }}
+{{ end }}{{ end }}
+{{ end }}`
+
+const defaultHTMLTemplate = `<html><body style="font-family:sans-serif">
+{{ range .Messages }}<div style="margin-bottom:16px">
+<h3 style="margin:0;color:{{ if eq .Type "firing" }}#c62828{{ else }}#2e7d32{{ end }}">{{ .Title }}</h3>
+<p style="margin:4px 0">{{ .Summary }}</p>
+<table style="font-size:13px;color:#555">
+<tr><td>Series</td><td><code>{{ .Alert.Series }}</code></td></tr>
+<tr><td>Value</td><td>{{ .Alert.Value }}</td></tr>
+<tr><td>Severity</td><td>{{ .Alert.Severity }}</td></tr>
+<tr><td>Since</td><td>{{ .Alert.ActiveAt.Format "2006-01-02 15:04:05 MST" }}</td></tr>
+{{ range $k, $v := .Alert.Annotations }}{{ if ne $k "summary" }}<tr><td>{{ $k }}</td><td>{{ $v }}</td></tr>{{ end }}{{ end }}
+</table></div>
+{{ end }}</body></html>`
+
+// emailData is what the body templates see.
+type emailData struct {
+	Host     string
+	Messages []*Message
+}
+
+type email struct {
+	cfg  config.EmailConfig
+	text *template.Template
+	html *htmltemplate.Template
+	// Bare addresses for the SMTP envelope; the headers keep the
+	// configured display forms
+	from string
+	to   []string
+}
+
+func newEmail(cfg config.EmailConfig) (*email, error) {
+	if cfg.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, errors.New("host, from and to are required")
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+	to := make([]string, 0, len(cfg.To))
+	for _, addr := range cfg.To {
+		a, err := mail.ParseAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("to %q: %w", addr, err)
+		}
+		to = append(to, a.Address)
+	}
+	switch cfg.TLS {
+	case "":
+		cfg.TLS = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("unknown tls mode %q", cfg.TLS)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = map[string]int{"starttls": 587, "tls": 465, "none": 25}[cfg.TLS]
+	}
+	switch cfg.Auth {
+	case "":
+		cfg.Auth = "plain"
+	case "plain", "login":
+	default:
+		return nil, fmt.Errorf("unknown auth %q", cfg.Auth)
+	}
+	textSrc, htmlSrc := cfg.TextTemplate, cfg.HTMLTemplate
+	if textSrc == "" {
+		textSrc = defaultTextTemplate
+	}
+	if htmlSrc == "" {
+		htmlSrc = defaultHTMLTemplate
+	}
+	text, err := template.New("text").Parse(textSrc)
+	if err != nil {
+		return nil, fmt.Errorf("text_template: %w", err)
+	}
+	html, err := htmltemplate.New("html").Parse(htmlSrc)
+	if err != nil {
+		return nil, fmt.Errorf("html_template: %w", err)
+	}
+	return &email{cfg: cfg, text: text, html: html, from: from.Address, to: to}, nil
+}
+
+func (m *email) Name() string { return m.cfg.Name }
+
+func (m *email) Window() time.Duration { return m.cfg.DigestWindow }
+
+func (m *email) Send(ctx context.Context, msg *Message) error {
+	return m.SendBatch(ctx, []*Message{msg})
+}
+
+func (m *email) SendBatch(ctx context.Context, msgs []*Message) error {
+	body, err := m.compose(msgs)
+	if err != nil {
+		return Permanent(err)
+	}
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: m.cfg.Host, MinVersion: tls.VersionTLS12}
+	if m.cfg.TLS == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if m.cfg.TLS == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return Permanent(errors.New("server does not support STARTTLS"))
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if m.cfg.Username != "" {
+		var auth smtp.Auth
+		if m.cfg.Auth == "login" {
+			auth = &loginAuth{username: m.cfg.Username, password: m.cfg.Password, host: m.cfg.Host}
+		} else {
+			auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+		}
+		if err := c.Auth(auth); err != nil {
+			return smtpError(err)
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return smtpError(err)
+	}
+	for _, to := range m.to {
+		if err := c.Rcpt(to); err != nil {
+			return smtpError(err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(err)
+	}
+	return c.Quit()
+}
+
+// smtpError marks 5xx replies as permanent.
+func smtpError(err error) error {
+	var te *textproto.Error
+	if errors.As(err, &te) && te.Code >= 500 {
+		return Permanent(err)
+	}
+	return err
+}
+
+func (m *email) compose(msgs []*Message) ([]byte, error) {
+	data := emailData{Host: msgs[0].Host, Messages: msgs}
+	var text, html bytes.Buffer
+	if err := m.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := m.html.Execute(&html, data); err != nil {
+		return nil, err
+	}
+	subject := msgs[0].Title()
+	if len(msgs) > 1 {
+		firing := 0
+		for _, msg := range msgs {
+			if msg.Type == "firing" {
+				firing++
+			}
+		}
+		subject = fmt.Sprintf("[Salvator] %d firing, %d resolved on %s", firing, len(msgs)-firing, data.Host)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	hdr := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	hdr("From", m.cfg.From)
+	hdr("To", strings.Join(m.cfg.To, ", "))
+	hdr("Subject", mime.QEncoding.Encode("utf-8", subject))
+	hdr("Date", time.Now().Format(time.RFC1123Z))
+	domain := m.cfg.Host
+	if i := strings.LastIndexByte(m.from, '@'); i >= 0 {
+		domain = m.from[i+1:]
+	}
+	hdr("Message-ID", "<"+randomHex(12)+"@"+domain+">")
+	hdr("MIME-Version", "1.0")
+	hdr("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct {
+		ctype string
+		body  []byte
+	}{{"text/plain; charset=utf-8", text.Bytes()}, {"text/html; charset=utf-8", html.Bytes()}} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.ctype},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		qw.Write(part.body)
+		qw.Close()
+	}
+	mw.Close()
+	return buf.Bytes(), nil
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp lacks. Like
+// smtp.PlainAuth it refuses to send credentials in the clear except to
+// localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSuffix(string(fromServer), ":")) {
+	case "username":
+		return []byte(a.username), nil
+	case "password":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,369 @@
+// Package notify delivers alert transitions to external channels. Every
+// notification is written to an outbox under the data directory before it
+// is sent, so deliveries survive restarts and are retried with backoff.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/alert"
+)
+
+// Message is what channels deliver: one firing or resolved transition.
+type Message struct {
+	ID    string      `json:"id"`
+	Type  string      `json:"type"`
+	Time  time.Time   `json:"time"`
+	Host  string      `json:"host"`
+	Alert alert.Alert `json:"alert"`
+}
+
+// Title is a one-line description such as "[FIRING] high_cpu on web1".
+func (m *Message) Title() string {
+	return fmt.Sprintf("[%s] %s on %s", strings.ToUpper(m.Type), m.Alert.Rule, m.Host)
+}
+
+// Summary is the alert's summary annotation, or the series and its value.
+func (m *Message) Summary() string {
+	if s := m.Alert.Annotations["summary"]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("%s = %g", m.Alert.Series, m.Alert.Value)
+}
+
+// Channel sends messages to one configured destination. Channel names are
+// unique and identify queued messages in the outbox.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Batcher is implemented by channels that combine the messages raised
+// within a window into a single delivery.
+type Batcher interface {
+	Channel
+	Window() time.Duration
+	SendBatch(ctx context.Context, msgs []*Message) error
+}
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the dispatcher drops the message instead of
+// retrying it.
+func Permanent(err error) error { return permanentError{err} }
+
+const (
+	minBackoff  = 10 * time.Second
+	maxBackoff  = 15 * time.Minute
+	sendTimeout = 30 * time.Second
+)
+
+type entry struct {
+	Channel     string    `json:"channel"`
+	Message     Message   `json:"message"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+
+	file string
+}
+
+// queue delivers one channel's messages strictly in order, so a resolved
+// message never overtakes the firing one.
+type queue struct {
+	ch      Channel
+	mu      sync.Mutex
+	entries []*entry
+	wake    chan struct{}
+}
+
+// Dispatcher turns alert events into messages for every channel.
+type Dispatcher struct {
+	dir    string
+	maxAge time.Duration
+	host   string
+	queues map[string]*queue
+	order  []string
+
+	// Channels and alerts a firing message was queued for, keyed like
+	// Refs and saved in dir/fired. Only these get a resolved message.
+	mu    sync.Mutex
+	fired map[string]time.Time
+}
+
+// NewDispatcher loads undelivered messages from the outbox in dir.
+// Messages for channels that no longer exist are discarded.
+func NewDispatcher(dir string, maxAge time.Duration, channels []Channel) (*Dispatcher, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	d := &Dispatcher{dir: dir, maxAge: maxAge, host: host, queues: map[string]*queue{}, fired: map[string]time.Time{}}
+	for _, c := range channels {
+		if _, dup := d.queues[c.Name()]; dup {
+			return nil, fmt.Errorf("notify: duplicate channel %q", c.Name())
+		}
+		d.queues[c.Name()] = &queue{ch: c, wake: make(chan struct{}, 1)}
+		d.order = append(d.order, c.Name())
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "fired"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &d.fired); err != nil {
+			return nil, err
+		}
+	}
+	for k, t := range d.fired {
+		if time.Since(t) > refRetention {
+			delete(d.fired, k)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		var e entry
+		b, err := os.ReadFile(f)
+		if err == nil {
+			err = json.Unmarshal(b, &e)
+		}
+		q := d.queues[e.Channel]
+		if err != nil || q == nil {
+			os.Remove(f)
+			continue
+		}
+		e.file = f
+		q.entries = append(q.entries, &e)
+	}
+	for _, q := range d.queues {
+		sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].Created.Before(q.entries[j].Created) })
+	}
+	return d, nil
+}
+
+// Notify queues firing transitions of alerts that are not silenced, and
+// resolved transitions on the channels that were sent the firing one. It is
+// meant to be registered with alert.Engine.AddListener.
+func (d *Dispatcher) Notify(ev alert.Event) {
+	firing := ev.Type == string(alert.StateFiring)
+	if !firing && ev.Type != string(alert.StateResolved) {
+		return
+	}
+	silenced := len(ev.Alert.SilencedBy) > 0
+	msg := Message{ID: ev.Alert.ID, Type: ev.Type, Time: ev.Time, Host: d.host, Alert: ev.Alert}
+	var targets []*queue
+	d.mu.Lock()
+	changed := false
+	for _, name := range d.order {
+		key := name + "/" + ev.Alert.ID
+		if firing {
+			if silenced {
+				continue
+			}
+			d.fired[key] = ev.Time
+			changed = true
+		} else {
+			if _, ok := d.fired[key]; !ok {
+				continue
+			}
+			delete(d.fired, key)
+			changed = true
+			if silenced {
+				continue
+			}
+		}
+		targets = append(targets, d.queues[name])
+	}
+	if changed {
+		d.saveFired()
+	}
+	d.mu.Unlock()
+	for _, q := range targets {
+		d.enqueue(q, msg)
+	}
+}
+
+func (d *Dispatcher) saveFired() {
+	b, err := json.Marshal(d.fired)
+	if err != nil {
+		return
+	}
+	path := filepath.Join(d.dir, "fired")
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0o600); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		log.Printf("notify: saving fired alerts failed: %v", err)
+	}
+}
+
+func (d *Dispatcher) enqueue(q *queue, msg Message) {
+	now := time.Now()
+	e := &entry{Channel: q.ch.Name(), Message: msg, Created: now, NextAttempt: now}
+	e.file = filepath.Join(d.dir, fmt.Sprintf("%d-%s-%s.json", now.UnixNano(), msg.ID, sanitize(q.ch.Name())))
+	if err := writeEntry(e); err != nil {
+		log.Printf("notify: outbox write failed, %s will not survive a restart: %v", q.ch.Name(), err)
+	}
+	q.mu.Lock()
+	q.entries = append(q.entries, e)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers queued messages until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, q := range d.queues {
+		wg.Add(1)
+		go func(q *queue) {
+			defer wg.Done()
+			d.runQueue(ctx, q)
+		}(q)
+	}
+	wg.Wait()
+}
+
+func (d *Dispatcher) runQueue(ctx context.Context, q *queue) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+		wait := d.drain(ctx, q)
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if wait > 0 {
+			timer.Reset(wait)
+		}
+	}
+}
+
+// drain sends due messages in order. It returns how long to wait before
+// the head of the queue is due again, or 0 when the queue is empty.
+func (d *Dispatcher) drain(ctx context.Context, q *queue) time.Duration {
+	for ctx.Err() == nil {
+		q.mu.Lock()
+		if len(q.entries) == 0 {
+			q.mu.Unlock()
+			return 0
+		}
+		e := q.entries[0]
+		q.mu.Unlock()
+
+		now := time.Now()
+		if d.maxAge > 0 && now.Sub(e.Created) > d.maxAge {
+			log.Printf("notify: %s: giving up on %s %s after %d attempts: %s", e.Channel, e.Message.Type, e.Message.Alert.Rule, e.Attempts, e.LastError)
+			d.remove(q, []*entry{e})
+			continue
+		}
+		if wait := e.NextAttempt.Sub(now); wait > 0 {
+			return wait
+		}
+		batch := []*entry{e}
+		if b, ok := q.ch.(Batcher); ok && b.Window() > 0 {
+			// Wait out the window opened by the oldest message
+			if wait := e.Created.Add(b.Window()).Sub(now); wait > 0 {
+				return wait
+			}
+			q.mu.Lock()
+			for _, next := range q.entries[1:] {
+				if next.Created.Sub(e.Created) > b.Window() {
+					break
+				}
+				batch = append(batch, next)
+			}
+			q.mu.Unlock()
+		}
+		sctx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := send(sctx, q.ch, batch)
+		cancel()
+		var perm permanentError
+		switch {
+		case err == nil:
+			d.remove(q, batch)
+		case errors.As(err, &perm):
+			log.Printf("notify: %s: dropping %d message(s) starting with %s %s: %v", e.Channel, len(batch), e.Message.Type, e.Message.Alert.Rule, err)
+			d.remove(q, batch)
+		default:
+			e.Attempts++
+			e.LastError = err.Error()
+			backoff := minBackoff << min(e.Attempts-1, 16)
+			e.NextAttempt = now.Add(min(backoff, maxBackoff))
+			writeEntry(e)
+			log.Printf("notify: %s: delivery failed (attempt %d), retrying at %s: %v", e.Channel, e.Attempts, e.NextAttempt.Format(time.RFC3339), err)
+		}
+	}
+	return 0
+}
+
+func send(ctx context.Context, ch Channel, batch []*entry) error {
+	if b, ok := ch.(Batcher); ok && b.Window() > 0 {
+		msgs := make([]*Message, len(batch))
+		for i, e := range batch {
+			msgs[i] = &e.Message
+		}
+		return b.SendBatch(ctx, msgs)
+	}
+	return ch.Send(ctx, &batch[0].Message)
+}
+
+// remove drops the delivered batch from the head of the queue.
+func (d *Dispatcher) remove(q *queue, batch []*entry) {
+	q.mu.Lock()
+	q.entries = q.entries[len(batch):]
+	q.mu.Unlock()
+	for _, e := range batch {
+		os.Remove(e.file)
+	}
+}
+
+func writeEntry(e *entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	tmp := e.file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.file)
+}
+
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofyr/server_monitor/server/internal/config"
+)
+
+// Default priorities by severity. Resolved alerts use the "resolved" entry.
+var (
+	ntfyPriorities   = map[string]int{"info": 3, "warning": 4, "critical": 5, "resolved": 2}
+	gotifyPriorities = map[string]int{"info": 4, "warning": 7, "critical": 10, "resolved": 2}
+	// Web Push urgency as understood by UnifiedPush distributors
+	pushUrgency = map[string]string{"info": "normal", "warning": "high", "critical": "high", "resolved": "low"}
+)
+
+func priority(msg *Message, defaults, overrides map[string]int) int {
+	key := msg.Alert.Severity
+	if msg.Type == "resolved" {
+		key = "resolved"
+	}
+	if p, ok := overrides[key]; ok {
+		return p
+	}
+	return defaults[key]
+}
+
+// ntfy publishes to a topic on an ntfy server.
+type ntfy struct {
+	cfg    config.NtfyConfig
+	client *http.Client
+}
+
+func newNtfy(cfg config.NtfyConfig) (*ntfy, error) {
+	if cfg.Topic == "" {
+		return nil, errors.New("topic required")
+	}
+	if cfg.URL == "" {
+		cfg.URL = "https://ntfy.sh"
+	}
+	return &ntfy{cfg: cfg, client: &http.Client{}}, nil
+}
+
+func (n *ntfy) Name() string { return n.cfg.Name }
+
+func (n *ntfy) Send(ctx context.Context, msg *Message) error {
+	url := strings.TrimSuffix(n.cfg.URL, "/") + "/" + n.cfg.Topic
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(msg.Summary()))
+	if err != nil {
+		return Permanent(err)
+	}
+	tag := "rotating_light"
+	if msg.Type == "resolved" {
+		tag = "white_check_mark"
+	}
+	req.Header.Set("Title", msg.Title())
+	req.Header.Set("Priority", strconv.Itoa(priority(msg, ntfyPriorities, n.cfg.Priorities)))
+	req.Header.Set("Tags", tag+","+msg.Alert.Severity)
+	if n.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.cfg.Token)
+	}
+	return doRequest(n.client, req)
+}
+
+// gotify posts to a Gotify server's message API with an application token.
+type gotify struct {
+	cfg    config.GotifyConfig
+	client *http.Client
+}
+
+func newGotify(cfg config.GotifyConfig) (*gotify, error) {
+	if cfg.URL == "" || cfg.Token == "" {
+		return nil, errors.New("url and token required")
+	}
+	return &gotify{cfg: cfg, client: &http.Client{}}, nil
+}
+
+func (g *gotify) Name() string { return g.cfg.Name }
+
+func (g *gotify) Send(ctx context.Context, msg *Message) error {
+	body, err := json.Marshal(map[string]any{
+		"title":    msg.Title(),
+		"message":  msg.Summary(),
+		"priority": priority(msg, gotifyPriorities, g.cfg.Priorities),
+		"extras":   map[string]any{"salvator::alert": msg},
+	})
+	if err != nil {
+		return Permanent(err)
+	}
+	url := strings.TrimSuffix(g.cfg.URL, "/") + "/message"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.cfg.Token)
+	return doRequest(g.client, req)
+}
+
+// unifiedPush posts the JSON message to the endpoint a UnifiedPush
+// distributor registered for the app.
+type unifiedPush struct {
+	cfg    config.UnifiedPushConfig
+	client *http.Client
+}
+
+func newUnifiedPush(cfg config.UnifiedPushConfig) (*unifiedPush, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("endpoint required")
+	}
+	return &unifiedPush{cfg: cfg, client: &http.Client{}}, nil
+}
+
+func (u *unifiedPush) Name() string { return u.cfg.Name }
+
+func (u *unifiedPush) Send(ctx context.Context, msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return Permanent(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	key := msg.Alert.Severity
+	if msg.Type == "resolved" {
+		key = "resolved"
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Urgency", pushUrgency[key])
+	req.Header.Set("TTL", fmt.Sprint(24*60*60))
+	if u.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.cfg.Token)
+	}
+	return doRequest(u.client, req)
+}
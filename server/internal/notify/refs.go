@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Refs older than this are forgotten; the alert most likely resolved while
+// the server was down.
+const refRetention = 30 * 24 * time.Hour
+
+type ref struct {
+	Value string    `json:"value"`
+	Time  time.Time `json:"time"`
+}
+
+// Refs remembers which chat message a channel posted for an alert, so the
+// resolved notification can edit or thread onto it. It is saved as JSON
+// after every change.
+type Refs struct {
+	path string
+	mu   sync.Mutex
+	m    map[string]ref
+}
+
+func OpenRefs(path string) (*Refs, error) {
+	r := &Refs{path: path, m: map[string]ref{}}
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &r.m); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range r.m {
+		if time.Since(v.Time) > refRetention {
+			delete(r.m, k)
+		}
+	}
+	return r, nil
+}
+
+func (r *Refs) get(channel, alertID string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.m[channel+"/"+alertID].Value
+}
+
+func (r *Refs) put(channel, alertID, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.m[channel+"/"+alertID] = ref{Value: value, Time: time.Now()}
+	r.save()
+}
+
+func (r *Refs) remove(channel, alertID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.m[channel+"/"+alertID]; ok {
+		delete(r.m, channel+"/"+alertID)
+		r.save()
+	}
+}
+
+func (r *Refs) save() {
+	b, err := json.Marshal(r.m)
+	if err != nil {
+		return
+	}
+	tmp := r.path + ".tmp"
+	if os.WriteFile(tmp, b, 0o600) == nil {
+		os.Rename(tmp, r.path)
+	}
+}
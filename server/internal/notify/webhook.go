@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+
+	"github.com/gofyr/server_monitor/server/internal/config"
+)
+
+// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the request
+// body keyed with the webhook secret.
+const SignatureHeader = "X-Salvator-Signature"
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+type webhook struct {
+	cfg    config.WebhookConfig
+	tmpl   *template.Template
+	client *http.Client
+}
+
+func newWebhook(cfg config.WebhookConfig) (*webhook, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("url required")
+	}
+	if cfg.ContentType == "" {
+		cfg.ContentType = "application/json"
+	}
+	w := &webhook{cfg: cfg, client: &http.Client{}}
+	if cfg.Template != "" {
+		t, err := template.New(cfg.Name).Funcs(templateFuncs).Parse(cfg.Template)
+		if err != nil {
+			return nil, err
+		}
+		w.tmpl = t
+	}
+	return w, nil
+}
+
+func (w *webhook) Name() string { return w.cfg.Name }
+
+func (w *webhook) Send(ctx context.Context, msg *Message) error {
+	var body []byte
+	if w.tmpl != nil {
+		var buf bytes.Buffer
+		if err := w.tmpl.Execute(&buf, msg); err != nil {
+			return Permanent(fmt.Errorf("template: %w", err))
+		}
+		body = buf.Bytes()
+	} else {
+		var err error
+		if body, err = json.Marshal(msg); err != nil {
+			return Permanent(err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", w.cfg.ContentType)
+	req.Header.Set("User-Agent", "salvator")
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.cfg.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.cfg.Secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return doRequest(w.client, req)
+}
+
+// doRequest performs req, treating 2xx as delivered and other 4xx than 408
+// and 429 as permanent failures.
+func doRequest(client *http.Client, req *http.Request) error {
+	return doJSON(client, req, nil)
+}
+
+// doJSON is doRequest that also decodes a successful JSON response into
+// out when out is not nil.
+func doJSON(client *http.Client, req *http.Request, out any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out != nil {
+			if err := json.Unmarshal(body, out); err != nil {
+				return fmt.Errorf("decoding response: %w", err)
+			}
+		}
+		return nil
+	}
+	if len(body) > 512 {
+		body = body[:512]
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,220 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The subset of the OTLP metrics data model Salvator produces: gauges and
+// cumulative monotonic sums of double data points.
+
+type dataPoint struct {
+	attrs map[string]string
+	start time.Time
+	t     time.Time
+	value float64
+}
+
+type metric struct {
+	name   string
+	sum    bool
+	points []dataPoint
+}
+
+type payload struct {
+	resource map[string]string
+	metrics  []metric
+}
+
+const (
+	scopeName = "salvator"
+	// AGGREGATION_TEMPORALITY_CUMULATIVE
+	temporalityCumulative = 2
+)
+
+// Protobuf encoding of ExportMetricsServiceRequest.
+
+func appendTag(b []byte, field int, wire byte) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, 2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendStringField(b []byte, field int, v string) []byte {
+	return appendBytesField(b, field, []byte(v))
+}
+
+func appendFixed64Field(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, 1)
+	return binary.LittleEndian.AppendUint64(b, v)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, 0)
+	return binary.AppendUvarint(b, v)
+}
+
+// keyValues encodes attributes as repeated KeyValue{key=1, value=2} with
+// AnyValue{string_value=1}, in key order.
+func appendKeyValues(b []byte, field int, attrs map[string]string) []byte {
+	for _, k := range sortedKeys(attrs) {
+		var value []byte
+		value = appendStringField(value, 1, attrs[k])
+		var kv []byte
+		kv = appendStringField(kv, 1, k)
+		kv = appendBytesField(kv, 2, value)
+		b = appendBytesField(b, field, kv)
+	}
+	return b
+}
+
+func (p *payload) marshalProto() []byte {
+	var resource []byte
+	resource = appendKeyValues(resource, 1, p.resource)
+
+	var scope []byte
+	scope = appendStringField(scope, 1, scopeName)
+
+	var scopeMetrics []byte
+	scopeMetrics = appendBytesField(scopeMetrics, 1, scope)
+	for _, m := range p.metrics {
+		var points [][]byte
+		for _, dp := range m.points {
+			var d []byte
+			if !dp.start.IsZero() {
+				d = appendFixed64Field(d, 2, uint64(dp.start.UnixNano()))
+			}
+			d = appendFixed64Field(d, 3, uint64(dp.t.UnixNano()))
+			d = appendFixed64Field(d, 4, math.Float64bits(dp.value))
+			d = appendKeyValues(d, 7, dp.attrs)
+			points = append(points, d)
+		}
+		var data []byte
+		for _, d := range points {
+			data = appendBytesField(data, 1, d)
+		}
+		var mb []byte
+		mb = appendStringField(mb, 1, m.name)
+		if m.sum {
+			data = appendVarintField(data, 2, temporalityCumulative)
+			data = appendVarintField(data, 3, 1)
+			mb = appendBytesField(mb, 7, data)
+		} else {
+			mb = appendBytesField(mb, 5, data)
+		}
+		scopeMetrics = appendBytesField(scopeMetrics, 2, mb)
+	}
+
+	var rm []byte
+	rm = appendBytesField(rm, 1, resource)
+	rm = appendBytesField(rm, 2, scopeMetrics)
+
+	var req []byte
+	return appendBytesField(req, 1, rm)
+}
+
+// JSON encoding following the OTLP/JSON mapping: lowerCamelCase fields,
+// 64-bit integers as strings and enums as numbers.
+
+type jsonKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type jsonDataPoint struct {
+	Attributes        []jsonKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type jsonData struct {
+	DataPoints             []jsonDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality,omitempty"`
+	IsMonotonic            bool            `json:"isMonotonic,omitempty"`
+}
+
+type jsonMetric struct {
+	Name  string    `json:"name"`
+	Gauge *jsonData `json:"gauge,omitempty"`
+	Sum   *jsonData `json:"sum,omitempty"`
+}
+
+func jsonAttrs(attrs map[string]string) []jsonKeyValue {
+	out := make([]jsonKeyValue, 0, len(attrs))
+	for _, k := range sortedKeys(attrs) {
+		kv := jsonKeyValue{Key: k}
+		kv.Value.StringValue = attrs[k]
+		out = append(out, kv)
+	}
+	return out
+}
+
+func (p *payload) marshalJSON() ([]byte, error) {
+	metrics := make([]jsonMetric, 0, len(p.metrics))
+	for _, m := range p.metrics {
+		data := &jsonData{DataPoints: make([]jsonDataPoint, 0, len(m.points))}
+		for _, dp := range m.points {
+			jp := jsonDataPoint{
+				Attributes:   jsonAttrs(dp.attrs),
+				TimeUnixNano: strconv.FormatInt(dp.t.UnixNano(), 10),
+				AsDouble:     dp.value,
+			}
+			if !dp.start.IsZero() {
+				jp.StartTimeUnixNano = strconv.FormatInt(dp.start.UnixNano(), 10)
+			}
+			data.DataPoints = append(data.DataPoints, jp)
+		}
+		jm := jsonMetric{Name: m.name}
+		if m.sum {
+			data.AggregationTemporality = temporalityCumulative
+			data.IsMonotonic = true
+			jm.Sum = data
+		} else {
+			jm.Gauge = data
+		}
+		metrics = append(metrics, jm)
+	}
+	type scope struct {
+		Name string `json:"name"`
+	}
+	type scopeMetrics struct {
+		Scope   scope        `json:"scope"`
+		Metrics []jsonMetric `json:"metrics"`
+	}
+	type resource struct {
+		Attributes []jsonKeyValue `json:"attributes"`
+	}
+	type resourceMetrics struct {
+		Resource     resource       `json:"resource"`
+		ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+	}
+	req := struct {
+		ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+	}{
+		ResourceMetrics: []resourceMetrics{{
+			Resource:     resource{Attributes: jsonAttrs(p.resource)},
+			ScopeMetrics: []scopeMetrics{{Scope: scope{Name: scopeName}, Metrics: metrics}},
+		}},
+	}
+	return json.Marshal(req)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
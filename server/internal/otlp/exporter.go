@@ -0,0 +1,203 @@
+// Package otlp pushes collected metrics to an OpenTelemetry collector over
+// OTLP/HTTP.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/config"
+	"github.com/gofyr/server_monitor/server/internal/sampler"
+	"github.com/gofyr/server_monitor/server/internal/series"
+)
+
+// Series that are cumulative counters since boot and exported as sums.
+var cumulative = map[string]bool{
+	"net_bytes_in":     true,
+	"net_bytes_out":    true,
+	"disk_read_bytes":  true,
+	"disk_write_bytes": true,
+}
+
+const maxBackoff = 5 * time.Minute
+
+type sample struct {
+	t      time.Time
+	boot   time.Time
+	values map[string]float64
+}
+
+// Exporter buffers snapshots from the hub and sends them in batches. While
+// the collector is unreachable it retries with exponential backoff; once
+// the buffer is full the oldest snapshots are dropped.
+type Exporter struct {
+	cfg      config.OTLPConfig
+	client   *http.Client
+	resource map[string]string
+
+	buf     []sample
+	backoff time.Duration
+	retryAt time.Time
+}
+
+func NewExporter(cfg config.OTLPConfig) (*Exporter, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("otlp: endpoint required")
+	}
+	if cfg.Encoding != "json" && cfg.Encoding != "protobuf" {
+		return nil, fmt.Errorf("otlp: unknown encoding %q", cfg.Encoding)
+	}
+	if cfg.Interval <= 0 || cfg.BatchSize <= 0 || cfg.MaxBuffer < cfg.BatchSize {
+		return nil, fmt.Errorf("otlp: interval and batch_size must be positive and max_buffer at least batch_size")
+	}
+	resource := map[string]string{"service.name": "salvator"}
+	if h, err := os.Hostname(); err == nil {
+		resource["host.name"] = h
+	}
+	for k, v := range cfg.ResourceAttributes {
+		resource[k] = v
+	}
+	return &Exporter{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}, resource: resource}, nil
+}
+
+// Run exports snapshots published on hub until ctx is cancelled.
+func (e *Exporter) Run(ctx context.Context, hub *sampler.Hub) {
+	ch, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case snap := <-ch:
+			e.add(snap)
+			if len(e.buf) >= e.cfg.BatchSize {
+				e.flush(ctx)
+			}
+		case <-ticker.C:
+			e.flush(ctx)
+		}
+	}
+}
+
+func (e *Exporter) add(snap *sampler.Snapshot) {
+	s := sample{t: snap.Time, values: snap.Series()}
+	if bt := snap.Metrics.BootTime; bt > 0 {
+		s.boot = time.Unix(int64(bt), 0)
+	}
+	e.buf = append(e.buf, s)
+	if over := len(e.buf) - e.cfg.MaxBuffer; over > 0 {
+		log.Printf("otlp: buffer full, dropping %d snapshots", over)
+		e.buf = append(e.buf[:0], e.buf[over:]...)
+	}
+}
+
+// flush sends buffered snapshots batch by batch until the buffer is empty
+// or a send fails.
+func (e *Exporter) flush(ctx context.Context) {
+	if time.Now().Before(e.retryAt) {
+		return
+	}
+	for len(e.buf) > 0 {
+		n := min(len(e.buf), e.cfg.BatchSize)
+		err := e.send(ctx, e.buf[:n])
+		if err == errPermanent {
+			e.buf = e.buf[n:]
+			continue
+		}
+		if err != nil {
+			if e.backoff == 0 {
+				e.backoff = time.Second
+			} else {
+				e.backoff = min(e.backoff*2, maxBackoff)
+			}
+			e.retryAt = time.Now().Add(e.backoff)
+			log.Printf("otlp: export failed, retrying in %s: %v", e.backoff, err)
+			return
+		}
+		e.backoff = 0
+		e.buf = e.buf[n:]
+	}
+}
+
+var errPermanent = fmt.Errorf("otlp: batch rejected")
+
+func (e *Exporter) send(ctx context.Context, batch []sample) error {
+	p := e.build(batch)
+	var body []byte
+	contentType := "application/x-protobuf"
+	if e.cfg.Encoding == "json" {
+		var err error
+		if body, err = p.marshalJSON(); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	} else {
+		body = p.marshalProto()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("collector returned %s", resp.Status)
+	default:
+		// Retrying a malformed or unauthorised request cannot succeed
+		log.Printf("otlp: collector rejected batch of %d snapshots: %s", len(batch), resp.Status)
+		return errPermanent
+	}
+}
+
+// build groups a batch of snapshots by metric name.
+func (e *Exporter) build(batch []sample) *payload {
+	byName := map[string]*metric{}
+	for _, s := range batch {
+		for key, v := range s.values {
+			name, labels, err := series.Parse(key)
+			if err != nil {
+				continue
+			}
+			m, ok := byName[name]
+			if !ok {
+				m = &metric{name: name, sum: cumulative[name]}
+				byName[name] = m
+			}
+			dp := dataPoint{attrs: labels, t: s.t, value: v}
+			if m.sum {
+				dp.start = s.boot
+			}
+			m.points = append(m.points, dp)
+		}
+	}
+	names := make([]string, 0, len(byName))
+	for n := range byName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	p := &payload{resource: e.resource}
+	for _, n := range names {
+		p.metrics = append(p.metrics, *byName[n])
+	}
+	return p
+}
@@ -0,0 +1,132 @@
+// Package prom reads and writes the Prometheus text exposition format.
+package prom
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type MetricType string
+
+const (
+	GaugeType   MetricType = "gauge"
+	CounterType MetricType = "counter"
+	UntypedType MetricType = "untyped"
+)
+
+const (
+	ContentType            = "text/plain; version=0.0.4; charset=utf-8"
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// Family is one metric with its samples. Counter names carry the _total
+// suffix.
+type Family struct {
+	Name    string
+	Help    string
+	Type    MetricType
+	Samples []Sample
+}
+
+// Write encodes families in the Prometheus text format, or in OpenMetrics
+// when openMetrics is set. Families without samples are skipped.
+func Write(w io.Writer, families []Family, openMetrics bool) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		name := f.Name
+		typ := f.Type
+		if openMetrics {
+			if typ == CounterType {
+				name = strings.TrimSuffix(name, "_total")
+			}
+			if typ == UntypedType {
+				typ = "unknown"
+			}
+		}
+		if f.Help != "" {
+			bw.WriteString("# HELP " + name + " " + escapeHelp(f.Help) + "\n")
+		}
+		bw.WriteString("# TYPE " + name + " " + string(typ) + "\n")
+		for _, s := range f.Samples {
+			bw.WriteString(f.Name)
+			writeLabels(bw, s.Labels)
+			bw.WriteByte(' ')
+			bw.WriteString(formatFloat(s.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+func writeLabels(bw *bufio.Writer, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	bw.WriteByte('{')
+	for i, k := range names {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString(k)
+		bw.WriteString(`="`)
+		bw.WriteString(escapeLabel(labels[k]))
+		bw.WriteByte('"')
+	}
+	bw.WriteByte('}')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(v string) string { return labelEscaper.Replace(v) }
+func escapeHelp(v string) string  { return helpEscaper.Replace(v) }
+
+// SanitizeName maps s onto a valid metric or label name.
+func SanitizeName(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9' && i > 0:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,238 @@
+// Package recorder is a flight recorder: it keeps the last few minutes of
+// high-resolution host samples in memory and freezes them to disk when an
+// alert fires or on request.
+package recorder
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/alert"
+	"github.com/gofyr/server_monitor/server/internal/config"
+)
+
+var ErrNotFound = errors.New("dump not found")
+
+// Dump is a frozen stretch of samples. Samples are left out of listings.
+type Dump struct {
+	ID string `json:"id"`
+	// alert or manual
+	Reason      string    `json:"reason"`
+	AlertID     string    `json:"alert_id,omitempty"`
+	Rule        string    `json:"rule,omitempty"`
+	Series      string    `json:"series,omitempty"`
+	User        string    `json:"user,omitempty"`
+	TriggeredAt time.Time `json:"triggered_at"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Samples     []Sample  `json:"samples,omitempty"`
+}
+
+type pending struct {
+	due  time.Time
+	dump Dump
+}
+
+// Recorder samples the host at a fixed interval into a ring buffer.
+type Recorder struct {
+	cfg     config.RecorderConfig
+	dir     string
+	sampler *hostSampler
+
+	mu      sync.Mutex
+	buf     []Sample
+	start   int
+	n       int
+	pending []pending
+	dumps   []Dump // newest last, without samples
+}
+
+func New(dir string, cfg config.RecorderConfig) (*Recorder, error) {
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("recorder: interval must be positive")
+	}
+	if cfg.Window < cfg.Interval {
+		return nil, fmt.Errorf("recorder: window must be at least one interval")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		cfg:     cfg,
+		dir:     dir,
+		sampler: newHostSampler(cfg.TopProcesses),
+		buf:     make([]Sample, int(cfg.Window/cfg.Interval)),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		d, err := r.load(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			log.Printf("recorder: skipping %s: %v", e.Name(), err)
+			continue
+		}
+		d.Samples = nil
+		r.dumps = append(r.dumps, *d)
+	}
+	sort.Slice(r.dumps, func(i, j int) bool { return r.dumps[i].TriggeredAt.Before(r.dumps[j].TriggeredAt) })
+	return r, nil
+}
+
+// Run samples until ctx is cancelled and writes alert dumps once their
+// trailing window has been recorded.
+func (r *Recorder) Run(ctx context.Context) {
+	t := time.NewTicker(r.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			sctx, cancel := context.WithTimeout(ctx, r.cfg.Interval)
+			s := r.sampler.sample(sctx, now)
+			cancel()
+			r.add(s)
+			for _, p := range r.due(now) {
+				if _, err := r.write(p.dump); err != nil {
+					log.Printf("recorder: dump for alert %s: %v", p.dump.AlertID, err)
+				}
+			}
+		}
+	}
+}
+
+func (r *Recorder) add(s Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = s
+		r.n++
+		return
+	}
+	r.buf[r.start] = s
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+func (r *Recorder) due(now time.Time) []pending {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []pending
+	kept := r.pending[:0]
+	for _, p := range r.pending {
+		if now.Before(p.due) {
+			kept = append(kept, p)
+		} else {
+			out = append(out, p)
+		}
+	}
+	r.pending = kept
+	return out
+}
+
+// Notify schedules a dump when an alert starts firing. The dump is written
+// after the configured trailing time, so it covers both the lead-up and
+// the start of the incident.
+func (r *Recorder) Notify(ev alert.Event) {
+	a := ev.Alert
+	if ev.Type != string(alert.StateFiring) || a.FiredAt == nil || !a.FiredAt.Equal(ev.Time) {
+		return
+	}
+	d := Dump{ID: newID(), Reason: "alert", AlertID: a.ID, Rule: a.Rule, Series: a.Series, TriggeredAt: ev.Time}
+	r.mu.Lock()
+	r.pending = append(r.pending, pending{due: ev.Time.Add(r.cfg.After), dump: d})
+	r.mu.Unlock()
+}
+
+// Dump freezes the samples recorded so far.
+func (r *Recorder) Dump(user string) (Dump, error) {
+	return r.write(Dump{ID: newID(), Reason: "manual", User: user, TriggeredAt: time.Now()})
+}
+
+func (r *Recorder) write(d Dump) (Dump, error) {
+	r.mu.Lock()
+	d.Samples = make([]Sample, 0, r.n)
+	for i := 0; i < r.n; i++ {
+		d.Samples = append(d.Samples, r.buf[(r.start+i)%len(r.buf)])
+	}
+	r.mu.Unlock()
+	if len(d.Samples) > 0 {
+		d.From, d.To = d.Samples[0].Time, d.Samples[len(d.Samples)-1].Time
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return Dump{}, err
+	}
+	path := filepath.Join(r.dir, d.ID+".json")
+	if err := os.WriteFile(path+".tmp", b, 0o600); err != nil {
+		return Dump{}, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return Dump{}, err
+	}
+	d.Samples = nil
+	r.mu.Lock()
+	r.dumps = append(r.dumps, d)
+	for r.cfg.MaxDumps > 0 && len(r.dumps) > r.cfg.MaxDumps {
+		os.Remove(filepath.Join(r.dir, r.dumps[0].ID+".json"))
+		r.dumps = r.dumps[1:]
+	}
+	r.mu.Unlock()
+	return d, nil
+}
+
+// List returns the stored dumps, newest first, without their samples.
+func (r *Recorder) List() []Dump {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Dump, len(r.dumps))
+	for i, d := range r.dumps {
+		out[len(out)-1-i] = d
+	}
+	return out
+}
+
+// Get loads a dump with its samples.
+func (r *Recorder) Get(id string) (*Dump, error) {
+	if id == "" || strings.Trim(id, "0123456789abcdef") != "" {
+		return nil, ErrNotFound
+	}
+	d, err := r.load(id)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return d, err
+}
+
+func (r *Recorder) load(id string) (*Dump, error) {
+	b, err := os.ReadFile(filepath.Join(r.dir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var d Dump
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,175 @@
+package recorder
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Sample is the host at one instant. Rates and process CPU are averages
+// since the previous sample; CPU is in percent of one core for processes.
+type Sample struct {
+	Time          time.Time `json:"time"`
+	CPU           float64   `json:"cpu_percent"`
+	MemUsed       uint64    `json:"mem_used"`
+	MemUsedPct    float64   `json:"mem_used_percent"`
+	SwapUsed      uint64    `json:"swap_used"`
+	DiskReadRate  float64   `json:"disk_read_rate"`
+	DiskWriteRate float64   `json:"disk_write_rate"`
+	NetInRate     float64   `json:"net_in_rate"`
+	NetOutRate    float64   `json:"net_out_rate"`
+	Processes     []Process `json:"top_processes"`
+	// Readings that failed, by name
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type Process struct {
+	PID    int32   `json:"pid"`
+	Name   string  `json:"name"`
+	CPU    float64 `json:"cpu"`
+	Memory uint64  `json:"memory"`
+}
+
+// hostSampler remembers the previous counters so each sample reports
+// activity over the last interval only.
+type hostSampler struct {
+	top int
+
+	at        time.Time
+	cpu       *cpu.TimesStat
+	diskRead  uint64
+	diskWrite uint64
+	netIn     uint64
+	netOut    uint64
+	procs     map[int32]*process.Process
+}
+
+func newHostSampler(top int) *hostSampler {
+	return &hostSampler{top: top, procs: map[int32]*process.Process{}}
+}
+
+func (h *hostSampler) sample(ctx context.Context, now time.Time) Sample {
+	s := Sample{Time: now, Processes: []Process{}}
+	fail := func(name string, err error) {
+		if s.Errors == nil {
+			s.Errors = map[string]string{}
+		}
+		s.Errors[name] = err.Error()
+	}
+	dt := now.Sub(h.at).Seconds()
+	first := h.at.IsZero()
+	h.at = now
+
+	if times, err := cpu.TimesWithContext(ctx, false); err != nil {
+		fail("cpu", err)
+	} else if len(times) > 0 {
+		cur := times[0]
+		if h.cpu != nil {
+			s.CPU = cpuPercent(*h.cpu, cur)
+		}
+		h.cpu = &cur
+	}
+	if vm, err := mem.VirtualMemoryWithContext(ctx); err != nil {
+		fail("memory", err)
+	} else {
+		s.MemUsed, s.MemUsedPct = vm.Used, vm.UsedPercent
+		s.SwapUsed = vm.SwapTotal - vm.SwapFree
+	}
+	if io, err := disk.IOCountersWithContext(ctx); err != nil {
+		fail("diskio", err)
+	} else {
+		var rd, wr uint64
+		for _, st := range io {
+			rd += st.ReadBytes
+			wr += st.WriteBytes
+		}
+		if !first {
+			s.DiskReadRate, s.DiskWriteRate = rate(h.diskRead, rd, dt), rate(h.diskWrite, wr, dt)
+		}
+		h.diskRead, h.diskWrite = rd, wr
+	}
+	if io, err := net.IOCountersWithContext(ctx, false); err != nil {
+		fail("network", err)
+	} else if len(io) > 0 {
+		if !first {
+			s.NetInRate, s.NetOutRate = rate(h.netIn, io[0].BytesRecv, dt), rate(h.netOut, io[0].BytesSent, dt)
+		}
+		h.netIn, h.netOut = io[0].BytesRecv, io[0].BytesSent
+	}
+	if h.top > 0 {
+		procs, err := h.topProcesses(ctx)
+		if err != nil {
+			fail("processes", err)
+		}
+		s.Processes = procs
+	}
+	return s
+}
+
+// topProcesses ranks processes by CPU since the previous sample. Processes
+// are kept between samples so their CPU times can be compared.
+func (h *hostSampler) topProcesses(ctx context.Context) ([]Process, error) {
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return []Process{}, err
+	}
+	seen := make(map[int32]*process.Process, len(pids))
+	list := make([]Process, 0, len(pids))
+	for _, pid := range pids {
+		if ctx.Err() != nil {
+			break
+		}
+		p := h.procs[pid]
+		if p == nil {
+			if p, err = process.NewProcessWithContext(ctx, pid); err != nil {
+				continue
+			}
+		}
+		pct, err := p.PercentWithContext(ctx, 0)
+		if err != nil {
+			continue
+		}
+		seen[pid] = p
+		list = append(list, Process{PID: pid, CPU: pct})
+	}
+	h.procs = seen
+	sort.Slice(list, func(i, j int) bool { return list[i].CPU > list[j].CPU })
+	list = list[:min(h.top, len(list))]
+	for i := range list {
+		p := seen[list[i].PID]
+		list[i].Name, _ = p.NameWithContext(ctx)
+		if mi, err := p.MemoryInfoWithContext(ctx); err == nil {
+			list[i].Memory = mi.RSS
+		}
+	}
+	return list, nil
+}
+
+func cpuPercent(prev, cur cpu.TimesStat) float64 {
+	// I/O wait counts as idle, as in cpu.Percent
+	busy := func(t cpu.TimesStat) float64 {
+		return t.User + t.System + t.Nice + t.Irq + t.Softirq + t.Steal
+	}
+	total := func(t cpu.TimesStat) float64 { return busy(t) + t.Idle + t.Iowait }
+	d := total(cur) - total(prev)
+	if d <= 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(100, (busy(cur)-busy(prev))/d*100))
+}
+
+// rate is the per-second increase of a counter; a counter that went
+// backwards was reset and yields zero.
+func rate(prev, cur uint64, dt float64) float64 {
+	if cur < prev || dt <= 0 {
+		return 0
+	}
+	return float64(cur-prev) / dt
+}
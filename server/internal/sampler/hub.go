@@ -0,0 +1,59 @@
+package sampler
+
+import "sync"
+
+// Hub fans out snapshots to any number of subscribers. Publishing never
+// blocks: a slow subscriber only ever sees the most recent snapshot.
+type Hub struct {
+	mu     sync.RWMutex
+	latest *Snapshot
+	subs   map[chan *Snapshot]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[chan *Snapshot]struct{})}
+}
+
+// Publish stores s as the latest snapshot and delivers it to all subscribers.
+// Snapshots must not be modified after they are published.
+func (h *Hub) Publish(s *Snapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latest = s
+	for ch := range h.subs {
+		select {
+		case ch <- s:
+		default:
+			// Drop the stale snapshot the subscriber has not read yet.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- s
+		}
+	}
+}
+
+// Latest returns the most recently published snapshot, or nil if none yet.
+func (h *Hub) Latest() *Snapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.latest
+}
+
+// Subscribe returns a channel receiving every published snapshot and a
+// function that must be called to release it.
+func (h *Hub) Subscribe() (<-chan *Snapshot, func()) {
+	ch := make(chan *Snapshot, 1)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, ch)
+			h.mu.Unlock()
+		})
+	}
+}
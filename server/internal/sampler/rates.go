@@ -0,0 +1,71 @@
+package sampler
+
+// computeRates fills the per-second rates of cur from the counters of prev.
+// Rates stay zero for the first snapshot and across a reboot, which is
+// detected by a change of BootTime. Counters that have not been re-read
+// since prev keep prev's rates. The totals are the sums of the
+// per-interface and per-device rates, so a device that disappears does not
+// show up as a drop in the summed counters.
+func computeRates(prev, cur *Snapshot) {
+	if prev == nil || prev.Metrics.BootTime != cur.Metrics.BootTime {
+		return
+	}
+	pm, m := &prev.Metrics, &cur.Metrics
+	if cur.netAt.Equal(prev.netAt) {
+		m.NetBytesInRate, m.NetBytesOutRate = pm.NetBytesInRate, pm.NetBytesOutRate
+		cur.Interfaces = prev.Interfaces
+	} else if dt := cur.netAt.Sub(prev.netAt).Seconds(); dt > 0 && !prev.netAt.IsZero() {
+		interfaceRates(prev, cur, dt)
+		for _, c := range cur.Interfaces {
+			m.NetBytesInRate += c.RecvRate
+			m.NetBytesOutRate += c.SentRate
+		}
+	}
+	if cur.diskAt.Equal(prev.diskAt) {
+		m.DiskReadRate, m.DiskWriteRate = pm.DiskReadRate, pm.DiskWriteRate
+		cur.Disks = prev.Disks
+	} else if dt := cur.diskAt.Sub(prev.diskAt).Seconds(); dt > 0 && !prev.diskAt.IsZero() {
+		diskRates(prev, cur, dt)
+		for _, c := range cur.Disks {
+			m.DiskReadRate += c.ReadRate
+			m.DiskWriteRate += c.WriteRate
+		}
+	}
+}
+
+func interfaceRates(prev, cur *Snapshot, dt float64) {
+	prevIfs := make(map[string]*InterfaceStat, len(prev.Interfaces))
+	for i := range prev.Interfaces {
+		prevIfs[prev.Interfaces[i].Name] = &prev.Interfaces[i]
+	}
+	for i := range cur.Interfaces {
+		c := &cur.Interfaces[i]
+		if p, ok := prevIfs[c.Name]; ok {
+			c.RecvRate = counterRate(p.BytesRecv, c.BytesRecv, dt)
+			c.SentRate = counterRate(p.BytesSent, c.BytesSent, dt)
+		}
+	}
+}
+
+func diskRates(prev, cur *Snapshot, dt float64) {
+	prevDisks := make(map[string]*DiskIOStat, len(prev.Disks))
+	for i := range prev.Disks {
+		prevDisks[prev.Disks[i].Name] = &prev.Disks[i]
+	}
+	for i := range cur.Disks {
+		c := &cur.Disks[i]
+		if p, ok := prevDisks[c.Name]; ok {
+			c.ReadRate = counterRate(p.ReadBytes, c.ReadBytes, dt)
+			c.WriteRate = counterRate(p.WriteBytes, c.WriteBytes, dt)
+		}
+	}
+}
+
+// counterRate returns the per-second increase of a cumulative counter. A
+// counter that went backwards was reset and yields zero.
+func counterRate(prev, cur uint64, dt float64) float64 {
+	if cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / dt
+}
@@ -0,0 +1,290 @@
+package sampler
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+
+	"github.com/gofyr/server_monitor/server/internal/collector"
+	"github.com/gofyr/server_monitor/server/internal/series"
+)
+
+type Metrics struct {
+	CPUPercent     float64            `json:"cpu_percent"`
+	Load1          float64            `json:"load1"`
+	Load5          float64            `json:"load5"`
+	Load15         float64            `json:"load15"`
+	MemoryUsed     uint64             `json:"memory_used"`
+	MemoryTotal    uint64             `json:"memory_total"`
+	SwapUsed       uint64             `json:"swap_used"`
+	SwapTotal      uint64             `json:"swap_total"`
+	DiskUsage      map[string]float64 `json:"disk_usage"`
+	NetBytesIn     uint64             `json:"net_bytes_in"`
+	NetBytesOut    uint64             `json:"net_bytes_out"`
+	DiskReadBytes  uint64             `json:"disk_read_bytes"`
+	DiskWriteBytes uint64             `json:"disk_write_bytes"`
+	BootTime       uint64             `json:"boot_time"`
+	Uptime         uint64             `json:"uptime"`
+
+	// Per-second rates of the cumulative counters above
+	NetBytesInRate  float64 `json:"net_bytes_in_rate"`
+	NetBytesOutRate float64 `json:"net_bytes_out_rate"`
+	DiskReadRate    float64 `json:"disk_read_rate"`
+	DiskWriteRate   float64 `json:"disk_write_rate"`
+
+	// Collectors whose data is missing from this snapshot, with the reason
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type InterfaceStat struct {
+	Name        string  `json:"name"`
+	BytesRecv   uint64  `json:"bytes_recv"`
+	BytesSent   uint64  `json:"bytes_sent"`
+	PacketsRecv uint64  `json:"packets_recv"`
+	PacketsSent uint64  `json:"packets_sent"`
+	ErrIn       uint64  `json:"err_in"`
+	ErrOut      uint64  `json:"err_out"`
+	RecvRate    float64 `json:"recv_rate"`
+	SentRate    float64 `json:"sent_rate"`
+}
+
+type DiskIOStat struct {
+	Name       string  `json:"name"`
+	ReadBytes  uint64  `json:"read_bytes"`
+	WriteBytes uint64  `json:"write_bytes"`
+	Reads      uint64  `json:"reads"`
+	Writes     uint64  `json:"writes"`
+	ReadRate   float64 `json:"read_rate"`
+	WriteRate  float64 `json:"write_rate"`
+}
+
+// Snapshot is an immutable point-in-time view of the host shared by all
+// metric consumers.
+type Snapshot struct {
+	Time    time.Time
+	Metrics Metrics
+	PerCPU  []float64
+	Memory  mem.VirtualMemoryStat
+	Swap    mem.SwapMemoryStat
+	Mounts  []collector.Mount
+
+	Interfaces []InterfaceStat
+	Disks      []DiskIOStat
+
+	// When the network and disk counters were read, for rate computation
+	netAt  time.Time
+	diskAt time.Time
+	// Collectors that contributed to this snapshot
+	present map[string]bool
+	// Values from additional series sources keyed by series key
+	extra map[string]float64
+}
+
+// SeriesSource contributes extra named values, such as check results or
+// ingested application metrics, to every snapshot.
+type SeriesSource interface {
+	Series() map[string]float64
+}
+
+// An Owner is a series source that reserves the metric names it returns:
+// values with those names from any other source are dropped. Sources that
+// report arbitrary names, such as ingested or scraped metrics, can then
+// not overwrite or fake check and heartbeat results.
+type Owner interface {
+	OwnedNames() []string
+}
+
+// Sampler assembles the latest results of the registry's host collectors
+// into a snapshot on a fixed interval and publishes it to a Hub, so the
+// cost of sampling does not depend on how many clients are connected.
+type Sampler struct {
+	interval time.Duration
+	hub      *Hub
+	reg      *collector.Registry
+	prev     *Snapshot
+
+	mu      sync.Mutex
+	sources []SeriesSource
+}
+
+func New(interval time.Duration, hub *Hub, reg *collector.Registry) *Sampler {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	return &Sampler{interval: interval, hub: hub, reg: reg}
+}
+
+// AddSource merges src's values into every subsequent snapshot. Values
+// using a built-in metric name, a name owned by another source or a key
+// already reported by an earlier source are dropped.
+func (s *Sampler) AddSource(src SeriesSource) {
+	s.mu.Lock()
+	s.sources = append(s.sources, src)
+	s.mu.Unlock()
+}
+
+// Reserved reports whether name is a built-in metric name or one owned by
+// a registered source.
+func (s *Sampler) Reserved(name string) bool {
+	if builtinNames[name] {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, src := range s.sources {
+		if o, ok := src.(Owner); ok && slices.Contains(o.OwnedNames(), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run samples until ctx is cancelled. The first snapshot is published after
+// a short warm-up so the collectors have completed their first run.
+func (s *Sampler) Run(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(200 * time.Millisecond):
+	}
+	s.publish()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.publish()
+		}
+	}
+}
+
+func (s *Sampler) publish() {
+	snap := s.sample()
+	computeRates(s.prev, snap)
+	s.prev = snap
+	s.hub.Publish(snap)
+}
+
+func (s *Sampler) sample() *Snapshot {
+	snap := &Snapshot{Time: time.Now(), present: map[string]bool{}}
+	m := &snap.Metrics
+	errs := map[string]string{}
+	value := func(name string) (any, time.Time) {
+		v, at, err := s.reg.Value(name)
+		if err != nil {
+			if err != collector.ErrDisabled {
+				errs[name] = err.Error()
+			}
+			return nil, time.Time{}
+		}
+		snap.present[name] = true
+		return v, at
+	}
+
+	if v, _ := value(collector.CPU); v != nil {
+		st := v.(collector.CPUStat)
+		m.CPUPercent = st.Total
+		snap.PerCPU = st.PerCPU
+	}
+	if v, _ := value(collector.Load); v != nil {
+		l := v.(*load.AvgStat)
+		m.Load1, m.Load5, m.Load15 = l.Load1, l.Load5, l.Load15
+	}
+	if v, _ := value(collector.Memory); v != nil {
+		st := v.(collector.MemoryStat)
+		snap.Memory, snap.Swap = st.Virtual, st.Swap
+		m.MemoryUsed, m.MemoryTotal = st.Virtual.Used, st.Virtual.Total
+		m.SwapUsed, m.SwapTotal = st.Swap.Used, st.Swap.Total
+	}
+	m.DiskUsage = map[string]float64{}
+	if v, _ := value(collector.Disk); v != nil {
+		snap.Mounts = v.([]collector.Mount)
+		for _, mt := range snap.Mounts {
+			m.DiskUsage[mt.Mountpoint] = mt.UsedPct
+		}
+	}
+	if v, at := value(collector.Network); v != nil {
+		ifs := v.([]net.IOCountersStat)
+		snap.netAt = at
+		snap.Interfaces = make([]InterfaceStat, 0, len(ifs))
+		for _, st := range ifs {
+			m.NetBytesIn += st.BytesRecv
+			m.NetBytesOut += st.BytesSent
+			snap.Interfaces = append(snap.Interfaces, InterfaceStat{
+				Name:        st.Name,
+				BytesRecv:   st.BytesRecv,
+				BytesSent:   st.BytesSent,
+				PacketsRecv: st.PacketsRecv,
+				PacketsSent: st.PacketsSent,
+				ErrIn:       st.Errin,
+				ErrOut:      st.Errout,
+			})
+		}
+	}
+	if v, at := value(collector.DiskIO); v != nil {
+		dio := v.(map[string]disk.IOCountersStat)
+		snap.diskAt = at
+		snap.Disks = make([]DiskIOStat, 0, len(dio))
+		for name, st := range dio {
+			m.DiskReadBytes += st.ReadBytes
+			m.DiskWriteBytes += st.WriteBytes
+			snap.Disks = append(snap.Disks, DiskIOStat{
+				Name:       name,
+				ReadBytes:  st.ReadBytes,
+				WriteBytes: st.WriteBytes,
+				Reads:      st.ReadCount,
+				Writes:     st.WriteCount,
+			})
+		}
+		sort.Slice(snap.Disks, func(i, j int) bool { return snap.Disks[i].Name < snap.Disks[j].Name })
+	}
+	if v, _ := value(collector.Host); v != nil {
+		m.BootTime = v.(collector.HostStat).BootTime
+		if now := uint64(snap.Time.Unix()); now > m.BootTime {
+			m.Uptime = now - m.BootTime
+		}
+	}
+	if len(errs) > 0 {
+		m.Errors = errs
+	}
+	s.mu.Lock()
+	sources := s.sources
+	s.mu.Unlock()
+	owners := map[string]int{}
+	for i, src := range sources {
+		if o, ok := src.(Owner); ok {
+			for _, name := range o.OwnedNames() {
+				owners[name] = i
+			}
+		}
+	}
+	for i, src := range sources {
+		for k, v := range src.Series() {
+			name, _, err := series.Parse(k)
+			if err != nil || builtinNames[name] {
+				continue
+			}
+			if owner, ok := owners[name]; ok && owner != i {
+				continue
+			}
+			if _, dup := snap.extra[k]; dup {
+				continue
+			}
+			if snap.extra == nil {
+				snap.extra = map[string]float64{}
+			}
+			snap.extra[k] = v
+		}
+	}
+	return snap
+}
@@ -0,0 +1,140 @@
+package sampler
+
+import (
+	"github.com/gofyr/server_monitor/server/internal/collector"
+	"github.com/gofyr/server_monitor/server/internal/series"
+)
+
+// Series flattens the snapshot into named values keyed by series key. Names
+// match the JSON fields of Metrics; per-mount values carry a mount label.
+// Values of collectors that failed or are disabled are left out rather than
+// reported as zero. Values from series sources are included as well.
+func (s *Snapshot) Series() map[string]float64 {
+	m := &s.Metrics
+	out := map[string]float64{}
+	if s.present[collector.CPU] {
+		out["cpu_percent"] = m.CPUPercent
+	}
+	if s.present[collector.Load] {
+		out["load1"] = m.Load1
+		out["load5"] = m.Load5
+		out["load15"] = m.Load15
+	}
+	if s.present[collector.Memory] {
+		out["memory_used"] = float64(m.MemoryUsed)
+		out["memory_total"] = float64(m.MemoryTotal)
+		out["swap_used"] = float64(m.SwapUsed)
+		out["swap_total"] = float64(m.SwapTotal)
+	}
+	if s.present[collector.Network] {
+		out["net_bytes_in"] = float64(m.NetBytesIn)
+		out["net_bytes_out"] = float64(m.NetBytesOut)
+		out["net_bytes_in_rate"] = m.NetBytesInRate
+		out["net_bytes_out_rate"] = m.NetBytesOutRate
+		for _, st := range s.Interfaces {
+			labels := map[string]string{"interface": st.Name}
+			out[series.Key("net_interface_recv_rate", labels)] = st.RecvRate
+			out[series.Key("net_interface_sent_rate", labels)] = st.SentRate
+		}
+	}
+	if s.present[collector.DiskIO] {
+		out["disk_read_bytes"] = float64(m.DiskReadBytes)
+		out["disk_write_bytes"] = float64(m.DiskWriteBytes)
+		out["disk_read_rate"] = m.DiskReadRate
+		out["disk_write_rate"] = m.DiskWriteRate
+		for _, st := range s.Disks {
+			labels := map[string]string{"device": st.Name}
+			out[series.Key("disk_device_read_rate", labels)] = st.ReadRate
+			out[series.Key("disk_device_write_rate", labels)] = st.WriteRate
+		}
+	}
+	if s.present[collector.Host] {
+		out["boot_time"] = float64(m.BootTime)
+		out["uptime"] = float64(m.Uptime)
+	}
+	for mount, pct := range m.DiskUsage {
+		out[series.Key("disk_usage", map[string]string{"mount": mount})] = pct
+	}
+	for k, v := range s.extra {
+		out[k] = v
+	}
+	return out
+}
+
+// builtinNames are the metric names Series produces from the collectors.
+// Series sources may not use them, whether or not the collector is
+// enabled.
+var builtinNames = map[string]bool{
+	"cpu_percent": true, "load1": true, "load5": true, "load15": true,
+	"memory_used": true, "memory_total": true, "swap_used": true, "swap_total": true,
+	"net_bytes_in": true, "net_bytes_out": true, "net_bytes_in_rate": true, "net_bytes_out_rate": true,
+	"net_interface_recv_rate": true, "net_interface_sent_rate": true,
+	"disk_read_bytes": true, "disk_write_bytes": true, "disk_read_rate": true, "disk_write_rate": true,
+	"disk_device_read_rate": true, "disk_device_write_rate": true,
+	"boot_time": true, "uptime": true, "disk_usage": true,
+}
+
+// Present reports whether the named collector contributed to the snapshot,
+// that is, whether it is enabled and its last run succeeded.
+func (s *Snapshot) Present(name string) bool {
+	return s.present[name]
+}
+
+// Extra returns the values contributed by series sources. The map must not
+// be modified.
+func (s *Snapshot) Extra() map[string]float64 {
+	return s.extra
+}
+
+// MetricsFromSeries rebuilds Metrics from values produced by Series.
+// Unknown keys are ignored.
+func MetricsFromSeries(values map[string]float64) Metrics {
+	m := Metrics{DiskUsage: map[string]float64{}}
+	for key, v := range values {
+		name, labels, err := series.Parse(key)
+		if err != nil {
+			continue
+		}
+		switch name {
+		case "cpu_percent":
+			m.CPUPercent = v
+		case "load1":
+			m.Load1 = v
+		case "load5":
+			m.Load5 = v
+		case "load15":
+			m.Load15 = v
+		case "memory_used":
+			m.MemoryUsed = uint64(v)
+		case "memory_total":
+			m.MemoryTotal = uint64(v)
+		case "swap_used":
+			m.SwapUsed = uint64(v)
+		case "swap_total":
+			m.SwapTotal = uint64(v)
+		case "net_bytes_in":
+			m.NetBytesIn = uint64(v)
+		case "net_bytes_out":
+			m.NetBytesOut = uint64(v)
+		case "disk_read_bytes":
+			m.DiskReadBytes = uint64(v)
+		case "disk_write_bytes":
+			m.DiskWriteBytes = uint64(v)
+		case "boot_time":
+			m.BootTime = uint64(v)
+		case "uptime":
+			m.Uptime = uint64(v)
+		case "net_bytes_in_rate":
+			m.NetBytesInRate = v
+		case "net_bytes_out_rate":
+			m.NetBytesOutRate = v
+		case "disk_read_rate":
+			m.DiskReadRate = v
+		case "disk_write_rate":
+			m.DiskWriteRate = v
+		case "disk_usage":
+			m.DiskUsage[labels["mount"]] = v
+		}
+	}
+	return m
+}
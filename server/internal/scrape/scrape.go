@@ -0,0 +1,143 @@
+// Package scrape pulls metrics from local Prometheus endpoints and keeps an
+// allowlisted subset of their series.
+package scrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/collector"
+	"github.com/gofyr/server_monitor/server/internal/config"
+	"github.com/gofyr/server_monitor/server/internal/prom"
+	"github.com/gofyr/server_monitor/server/internal/series"
+)
+
+// Responses larger than this are rejected.
+const maxBody = 10 << 20
+
+// Scraper owns the configured targets. Each target runs as a collector in
+// the registry; kept samples are exposed as series with a target label.
+type Scraper struct {
+	reg     *collector.Registry
+	targets []config.ScrapeTarget
+}
+
+func New(reg *collector.Registry, targets []config.ScrapeTarget) (*Scraper, error) {
+	s := &Scraper{reg: reg, targets: targets}
+	client := &http.Client{}
+	seen := map[string]bool{}
+	for _, t := range targets {
+		if t.Name == "" || t.URL == "" {
+			return nil, errors.New("scrape: name and url are required")
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("scrape: duplicate target %q", t.Name)
+		}
+		seen[t.Name] = true
+		if len(t.Allow) == 0 {
+			return nil, fmt.Errorf("scrape: target %q needs an allow list", t.Name)
+		}
+		tg := &target{cfg: t, client: client, maxSeries: t.MaxSeries}
+		for _, pat := range t.Allow {
+			re, err := regexp.Compile(pat)
+			if err != nil {
+				return nil, fmt.Errorf("scrape: target %q: %w", t.Name, err)
+			}
+			tg.allow = append(tg.allow, re)
+		}
+		if tg.maxSeries <= 0 {
+			tg.maxSeries = 500
+		}
+		interval := t.Interval
+		if interval <= 0 {
+			interval = 30 * time.Second
+		}
+		timeout := t.Timeout
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		reg.Register(tg, collector.Options{Enabled: true, Interval: interval, Timeout: timeout})
+	}
+	return s, nil
+}
+
+// Series returns the kept samples of every target's latest scrape.
+func (s *Scraper) Series() map[string]float64 {
+	out := map[string]float64{}
+	for _, t := range s.targets {
+		v, _, err := s.reg.Value(collectorName(t.Name))
+		if err != nil {
+			continue
+		}
+		for k, val := range v.(map[string]float64) {
+			out[k] = val
+		}
+	}
+	return out
+}
+
+func collectorName(target string) string { return "scrape:" + target }
+
+type target struct {
+	cfg       config.ScrapeTarget
+	client    *http.Client
+	allow     []*regexp.Regexp
+	maxSeries int
+}
+
+func (t *target) Name() string { return collectorName(t.cfg.Name) }
+
+func (t *target) Collect(ctx context.Context) (any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	if t.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+t.cfg.BearerToken)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	samples, err := prom.Parse(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]float64{}
+	for _, s := range samples {
+		if !t.allowed(s.Name) || math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		if len(out) >= t.maxSeries {
+			return nil, fmt.Errorf("more than %d allowed series", t.maxSeries)
+		}
+		labels := map[string]string{"target": t.cfg.Name}
+		for k, v := range s.Labels {
+			if k != "target" {
+				labels[k] = v
+			}
+		}
+		out[series.Key(s.Name, labels)] = s.Value
+	}
+	return out, nil
+}
+
+func (t *target) allowed(name string) bool {
+	for _, re := range t.allow {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+// Package series names individual metric time series. A key is a metric
+// name optionally followed by sorted labels, e.g. disk_usage{mount="/"}.
+package series
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Key formats name and labels into a canonical series key.
+func Key(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escape(labels[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Parse splits a series key back into its name and labels.
+func Parse(key string) (string, map[string]string, error) {
+	i := strings.IndexByte(key, '{')
+	if i < 0 {
+		return key, nil, nil
+	}
+	if !strings.HasSuffix(key, "}") {
+		return "", nil, errors.New("unterminated label set")
+	}
+	name := key[:i]
+	rest := key[i+1 : len(key)-1]
+	labels := map[string]string{}
+	for rest != "" {
+		eq := strings.Index(rest, `="`)
+		if eq <= 0 {
+			return "", nil, errors.New("malformed label")
+		}
+		k := rest[:eq]
+		rest = rest[eq+2:]
+		var v strings.Builder
+		closed := false
+		for j := 0; j < len(rest); j++ {
+			c := rest[j]
+			if c == '\\' && j+1 < len(rest) {
+				j++
+				switch rest[j] {
+				case 'n':
+					v.WriteByte('\n')
+				default:
+					v.WriteByte(rest[j])
+				}
+				continue
+			}
+			if c == '"' {
+				rest = rest[j+1:]
+				closed = true
+				break
+			}
+			v.WriteByte(c)
+		}
+		if !closed {
+			return "", nil, errors.New("unterminated label value")
+		}
+		labels[k] = v.String()
+		rest = strings.TrimPrefix(rest, ",")
+	}
+	return name, labels, nil
+}
+
+// Name returns the metric name part of key.
+func Name(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+func escape(v string) string {
+	if !strings.ContainsAny(v, "\\\"\n") {
+		return v
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return r.Replace(v)
+}
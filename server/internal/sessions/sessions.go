@@ -0,0 +1,223 @@
+// Package sessions tracks logins server-side. Each login is a session whose
+// refresh token is rotated on every use; presenting an already-rotated
+// refresh token revokes the session, since either it or its successor was
+// stolen.
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Last-use times are written at most this often per session.
+const lastUsedPersist = time.Minute
+
+var (
+	ErrNotFound  = errors.New("session not found")
+	ErrReused    = errors.New("refresh token reused")
+	ErrForbidden = errors.New("session belongs to another user")
+)
+
+type Session struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	LastIP     string    `json:"last_ip"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type record struct {
+	Session
+	// ID of the only refresh token that may be used next
+	RefreshJTI string    `json:"refresh_jti"`
+	savedAt    time.Time // last persisted use
+}
+
+// Store keeps sessions in a JSON file. Sessions expire when their refresh
+// token is not used within ttl.
+type Store struct {
+	path string
+	ttl  time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*record
+}
+
+func Open(path string, ttl time.Duration) (*Store, error) {
+	s := &Store{path: path, ttl: ttl, sessions: map[string]*record{}}
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		var list []*record
+		if err := json.Unmarshal(b, &list); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for _, r := range list {
+			if now.Before(r.ExpiresAt) {
+				s.sessions[r.ID] = r
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) save() error {
+	now := time.Now()
+	list := make([]*record, 0, len(s.sessions))
+	for id, r := range s.sessions {
+		if !now.Before(r.ExpiresAt) {
+			delete(s.sessions, id)
+			continue
+		}
+		r.savedAt = now
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Create starts a session and returns the ID of its first refresh token.
+func (s *Store) Create(username, ip, userAgent string) (Session, string, error) {
+	now := time.Now().UTC()
+	r := &record{
+		Session: Session{
+			ID:         newID(),
+			Username:   username,
+			CreatedAt:  now,
+			LastUsedAt: now,
+			LastIP:     ip,
+			UserAgent:  userAgent,
+			ExpiresAt:  now.Add(s.ttl),
+		},
+		RefreshJTI: newID(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[r.ID] = r
+	if err := s.save(); err != nil {
+		delete(s.sessions, r.ID)
+		return Session{}, "", err
+	}
+	return r.Session, r.RefreshJTI, nil
+}
+
+// Rotate exchanges refresh token jti of session id for a new one and
+// extends the session. A jti that is no longer current revokes the session
+// and returns ErrReused.
+func (s *Store) Rotate(id, jti, ip, userAgent string) (Session, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.sessions[id]
+	now := time.Now().UTC()
+	if !ok || !now.Before(r.ExpiresAt) {
+		return Session{}, "", ErrNotFound
+	}
+	if jti != r.RefreshJTI {
+		log.Printf("sessions: refresh token reused in session %s of %s from %s, revoking it", id, r.Username, ip)
+		delete(s.sessions, id)
+		s.save()
+		return Session{}, "", ErrReused
+	}
+	prev := *r
+	r.RefreshJTI = newID()
+	r.LastUsedAt, r.LastIP, r.ExpiresAt = now, ip, now.Add(s.ttl)
+	if userAgent != "" {
+		r.UserAgent = userAgent
+	}
+	if err := s.save(); err != nil {
+		*r = prev
+		return Session{}, "", err
+	}
+	return r.Session, r.RefreshJTI, nil
+}
+
+// Touch records a request made with an access token of session id and
+// returns the session if it is still active.
+func (s *Store) Touch(id, ip string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.sessions[id]
+	now := time.Now().UTC()
+	if !ok || !now.Before(r.ExpiresAt) {
+		return Session{}, false
+	}
+	r.LastUsedAt, r.LastIP = now, ip
+	if now.Sub(r.savedAt) >= lastUsedPersist {
+		s.save()
+	}
+	return r.Session, true
+}
+
+// List returns the active sessions of username, or of everyone when
+// username is empty, most recently used first.
+func (s *Store) List(username string) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	out := make([]Session, 0, len(s.sessions))
+	for _, r := range s.sessions {
+		if now.Before(r.ExpiresAt) && (username == "" || r.Username == username) {
+			out = append(out, r.Session)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastUsedAt.After(out[j].LastUsedAt) })
+	return out
+}
+
+// Revoke ends a session. Unless username is empty, only that user's
+// sessions can be revoked.
+func (s *Store) Revoke(id, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if username != "" && r.Username != username {
+		return ErrForbidden
+	}
+	delete(s.sessions, id)
+	return s.save()
+}
+
+// RevokeUser ends all sessions of username.
+func (s *Store) RevokeUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.sessions)
+	for id, r := range s.sessions {
+		if r.Username == username {
+			delete(s.sessions, id)
+		}
+	}
+	if len(s.sessions) == n {
+		return nil
+	}
+	return s.save()
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,243 @@
+// Package tokens manages long-lived API tokens for scripts. A token acts
+// on behalf of the user who created it, limited to its scopes.
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix marks API tokens, telling them apart from login JWTs.
+const Prefix = "slv_"
+
+// Expired tokens are listed for this long before they are dropped.
+const expiredRetention = 30 * 24 * time.Hour
+
+// Last-use times are written at most this often per token.
+const lastUsedPersist = time.Minute
+
+var (
+	ErrNotFound  = errors.New("token not found")
+	ErrInvalid   = errors.New("invalid token")
+	ErrForbidden = errors.New("token belongs to another user")
+)
+
+// Scopes are <resource>:<read|write|*>, where the resource is the first
+// path segment after /api (metrics, alerts, services, ...), or * for all.
+var scopePattern = regexp.MustCompile(`^(\*|[a-z_]+:(read|write|\*)|\*:read)$`)
+
+type Token struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Username   string     `json:"username"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Expired    bool       `json:"expired,omitempty"`
+}
+
+type record struct {
+	Token
+	SecretSHA256 string `json:"secret_sha256"`
+}
+
+// Store keeps tokens in a JSON file with only a hash of each secret.
+type Store struct {
+	path string
+
+	mu     sync.Mutex
+	tokens map[string]*record // by ID
+	saved  map[string]time.Time
+}
+
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, tokens: map[string]*record{}, saved: map[string]time.Time{}}
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		var list []*record
+		if err := json.Unmarshal(b, &list); err != nil {
+			return nil, err
+		}
+		for _, t := range list {
+			if time.Since(t.ExpiresAt) < expiredRetention {
+				s.tokens[t.ID] = t
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) save() error {
+	list := make([]*record, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Create issues a token for username and returns its secret, which is not
+// stored.
+func (s *Store) Create(username, name string, scopes []string, ttl time.Duration) (Token, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return Token{}, "", errors.New("name must be 1-100 characters")
+	}
+	if len(scopes) == 0 {
+		return Token{}, "", errors.New("at least one scope is required")
+	}
+	for _, sc := range scopes {
+		if !scopePattern.MatchString(sc) {
+			return Token{}, "", errors.New("invalid scope " + sc)
+		}
+	}
+	if ttl <= 0 {
+		return Token{}, "", errors.New("expiry must be in the future")
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return Token{}, "", err
+	}
+	secret := Prefix + hex.EncodeToString(raw)
+	sum := sha256.Sum256([]byte(secret))
+	now := time.Now().UTC()
+	t := &record{
+		Token: Token{
+			ID:        newID(),
+			Name:      name,
+			Username:  username,
+			Scopes:    scopes,
+			CreatedAt: now,
+			ExpiresAt: now.Add(ttl),
+		},
+		SecretSHA256: hex.EncodeToString(sum[:]),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[t.ID] = t
+	if err := s.save(); err != nil {
+		delete(s.tokens, t.ID)
+		return Token{}, "", err
+	}
+	return t.Token, secret, nil
+}
+
+// List returns the tokens of username, or of everyone when username is
+// empty, newest first.
+func (s *Store) List(username string) []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	out := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		if username == "" || t.Username == username {
+			v := t.Token
+			v.Expired = now.After(t.ExpiresAt)
+			out = append(out, v)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// Revoke deletes a token. Unless username is empty, only that user's
+// tokens can be revoked.
+func (s *Store) Revoke(id, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if username != "" && t.Username != username {
+		return ErrForbidden
+	}
+	delete(s.tokens, id)
+	return s.save()
+}
+
+// RevokeUser deletes all tokens of username.
+func (s *Store) RevokeUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.tokens)
+	for id, t := range s.tokens {
+		if t.Username == username {
+			delete(s.tokens, id)
+		}
+	}
+	if len(s.tokens) == n {
+		return nil
+	}
+	return s.save()
+}
+
+// Authenticate looks up an unexpired token by its secret and records the
+// use.
+func (s *Store) Authenticate(secret string) (Token, error) {
+	sum := sha256.Sum256([]byte(secret))
+	hash := hex.EncodeToString(sum[:])
+	now := time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.SecretSHA256 != hash {
+			continue
+		}
+		if now.After(t.ExpiresAt) {
+			return Token{}, ErrInvalid
+		}
+		t.LastUsedAt = &now
+		if now.Sub(s.saved[t.ID]) >= lastUsedPersist {
+			s.saved[t.ID] = now
+			s.save()
+		}
+		return t.Token, nil
+	}
+	return Token{}, ErrInvalid
+}
+
+// Allows reports whether scopes grant access to resource for a read or a
+// write. A write scope includes reading.
+func Allows(scopes []string, resource string, write bool) bool {
+	for _, sc := range scopes {
+		res, action, _ := strings.Cut(sc, ":")
+		if sc == "*" || (res == "*" && !write) {
+			return true
+		}
+		if res != resource {
+			continue
+		}
+		if action == "*" || action == "write" || (action == "read" && !write) {
+			return true
+		}
+	}
+	return false
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,429 @@
+// Package tsdb is a small embedded time-series store. Samples are written to
+// a raw tier and rolled up into coarser tiers of avg/min/max; every tier
+// lives in its own directory of time-partitioned segment files and is pruned
+// once segments fall out of its retention.
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/sampler"
+)
+
+// Tier is one retention level. A zero Resolution denotes the raw tier.
+type Tier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// Point is a value over a bucket of Count samples starting at Time.
+type Point struct {
+	Time  time.Time `json:"-"`
+	Avg   float64   `json:"avg"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Count uint64    `json:"-"`
+}
+
+func (p *Point) merge(o Point) {
+	if p.Count == 0 {
+		*p = Point{Time: p.Time, Avg: o.Avg, Min: o.Min, Max: o.Max, Count: o.Count}
+		return
+	}
+	total := p.Count + o.Count
+	p.Avg = (p.Avg*float64(p.Count) + o.Avg*float64(o.Count)) / float64(total)
+	if o.Min < p.Min {
+		p.Min = o.Min
+	}
+	if o.Max > p.Max {
+		p.Max = o.Max
+	}
+	p.Count = total
+}
+
+type tier struct {
+	Tier
+	dir  string
+	span time.Duration
+	seg  *segment
+
+	// Open rollup bucket, flushed once a sample lands in a later bucket
+	bucket time.Time
+	agg    map[string]Point
+}
+
+type DB struct {
+	mu    sync.Mutex
+	tiers []*tier
+}
+
+// Open opens or creates a store under dir. The first tier must be raw and
+// every later tier's resolution a multiple of the previous one. Rollup
+// buckets lost in a crash are rebuilt from the tier below.
+func Open(dir string, tiers []Tier) (*DB, error) {
+	if len(tiers) == 0 || tiers[0].Resolution != 0 {
+		return nil, errors.New("tsdb: first tier must be raw")
+	}
+	db := &DB{}
+	for i, t := range tiers {
+		if t.Retention <= 0 {
+			return nil, fmt.Errorf("tsdb: tier %d needs a retention", i)
+		}
+		if i > 0 {
+			prev := tiers[i-1].Resolution
+			if t.Resolution <= prev || (prev > 0 && t.Resolution%prev != 0) {
+				return nil, fmt.Errorf("tsdb: tier %d resolution must be a multiple of tier %d", i, i-1)
+			}
+		}
+		td := &tier{Tier: t, dir: filepath.Join(dir, tierName(t.Resolution)), span: segmentSpan(t.Resolution)}
+		if err := os.MkdirAll(td.dir, 0o700); err != nil {
+			return nil, err
+		}
+		db.tiers = append(db.tiers, td)
+	}
+	for i := 1; i < len(db.tiers); i++ {
+		if err := db.catchUp(db.tiers[i], db.tiers[i-1]); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+func tierName(res time.Duration) string {
+	if res == 0 {
+		return "raw"
+	}
+	return strconv.FormatInt(int64(res/time.Second), 10) + "s"
+}
+
+// segmentSpan keeps segment files to a few hundred buckets each.
+func segmentSpan(res time.Duration) time.Duration {
+	if res == 0 {
+		return time.Hour
+	}
+	return res * 720
+}
+
+// catchUp replays the lower tier into t from just after t's last written
+// bucket, restoring the open bucket and any buckets closed while down.
+func (db *DB) catchUp(t, lower *tier) error {
+	var last time.Time
+	segs, err := t.segments()
+	if err != nil {
+		return err
+	}
+	if len(segs) > 0 {
+		err := readSegment(segs[len(segs)-1].path, func(r record) {
+			if r.t.After(last) {
+				last = r.t
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	from := time.Time{}
+	if !last.IsZero() {
+		from = last.Add(t.Resolution)
+	}
+	recs, err := lower.read(from, time.Now().Add(24*time.Hour), nil)
+	if err != nil {
+		return err
+	}
+	for _, r := range recs {
+		if err := t.add(r.t, r.points); err != nil {
+			return err
+		}
+	}
+	if len(lower.agg) > 0 {
+		return t.add(lower.bucket, lower.agg)
+	}
+	return nil
+}
+
+// Append stores one sample of every series in values at time ts.
+func (db *DB) Append(ts time.Time, values map[string]float64) error {
+	points := make(map[string]Point, len(values))
+	for k, v := range values {
+		points[k] = Point{Time: ts, Avg: v, Min: v, Max: v, Count: 1}
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.tiers[0].write(ts, points); err != nil {
+		return err
+	}
+	for _, t := range db.tiers[1:] {
+		if err := t.add(ts, points); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add folds points into the open bucket, flushing it first if ts falls in a
+// later bucket.
+func (t *tier) add(ts time.Time, points map[string]Point) error {
+	b := ts.Truncate(t.Resolution)
+	if !b.Equal(t.bucket) {
+		if len(t.agg) > 0 {
+			if err := t.write(t.bucket, t.agg); err != nil {
+				return err
+			}
+		}
+		t.bucket, t.agg = b, map[string]Point{}
+	}
+	for k, p := range points {
+		cur := t.agg[k]
+		cur.Time = b
+		cur.merge(p)
+		t.agg[k] = cur
+	}
+	return nil
+}
+
+func (t *tier) write(ts time.Time, points map[string]Point) error {
+	start := ts.Truncate(t.span)
+	if t.seg == nil || !t.seg.start.Equal(start) {
+		if t.seg != nil {
+			if err := t.seg.close(); err != nil {
+				log.Printf("tsdb: close segment: %v", err)
+			}
+			t.seg = nil
+		}
+		seg, err := openSegment(filepath.Join(t.dir, segmentName(start)), start)
+		if err != nil {
+			return err
+		}
+		t.seg = seg
+	}
+	return t.seg.append(ts, points, t.Resolution == 0)
+}
+
+func segmentName(start time.Time) string {
+	return strconv.FormatInt(start.Unix(), 10) + ".seg"
+}
+
+type segmentFile struct {
+	start time.Time
+	path  string
+}
+
+// segments lists the tier's segment files in chronological order.
+func (t *tier) segments() ([]segmentFile, error) {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]segmentFile, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".seg") {
+			continue
+		}
+		secs, err := strconv.ParseInt(strings.TrimSuffix(name, ".seg"), 10, 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, segmentFile{start: time.Unix(secs, 0), path: filepath.Join(t.dir, name)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].start.Before(out[j].start) })
+	return out, nil
+}
+
+// read returns the stored records within [from, to], restricted to keys
+// when keys is non-nil.
+func (t *tier) read(from, to time.Time, keys map[string]bool) ([]record, error) {
+	segs, err := t.segments()
+	if err != nil {
+		return nil, err
+	}
+	var out []record
+	for _, s := range segs {
+		if s.start.After(to) || !s.start.Add(t.span).After(from) {
+			continue
+		}
+		err := readSegment(s.path, func(r record) {
+			if r.t.Before(from) || r.t.After(to) {
+				return
+			}
+			if keys != nil {
+				for k := range r.points {
+					if !keys[k] {
+						delete(r.points, k)
+					}
+				}
+			}
+			if len(r.points) > 0 {
+				out = append(out, r)
+			}
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].t.Before(out[j].t) })
+	return out, nil
+}
+
+// Query returns points for the requested series keys (all series when keys
+// is empty) between from and to. The coarsest tier that still covers from
+// at no more than step resolution is used; a positive step further merges
+// points into step-sized buckets aligned to from.
+func (db *DB) Query(keys []string, from, to time.Time, step time.Duration) (map[string][]Point, error) {
+	var want map[string]bool
+	if len(keys) > 0 {
+		want = make(map[string]bool, len(keys))
+		for _, k := range keys {
+			want[k] = true
+		}
+	}
+	// Only the tier choice and its open bucket need the lock; sealed
+	// records can be read while appends continue.
+	db.mu.Lock()
+	t := db.pickTier(from, step)
+	var open *record
+	if len(t.agg) > 0 && !t.bucket.Before(from) && !t.bucket.After(to) {
+		open = &record{t: t.bucket, points: make(map[string]Point, len(t.agg))}
+		for k, p := range t.agg {
+			if want == nil || want[k] {
+				open.points[k] = p
+			}
+		}
+	}
+	db.mu.Unlock()
+
+	recs, err := t.read(from, to, want)
+	if err != nil {
+		return nil, err
+	}
+	// The open bucket may have been flushed since the lock was released,
+	// in which case it was read back from the segment already.
+	if open != nil && (len(recs) == 0 || recs[len(recs)-1].t.Before(open.t)) {
+		recs = append(recs, *open)
+	}
+	out := map[string][]Point{}
+	for _, r := range recs {
+		for k, p := range r.points {
+			list := out[k]
+			if step > 0 && len(list) > 0 {
+				last := &list[len(list)-1]
+				if int64(last.Time.Sub(from)/step) == int64(p.Time.Sub(from)/step) {
+					last.merge(p)
+					continue
+				}
+			}
+			if step > 0 {
+				p.Time = from.Add(p.Time.Sub(from) / step * step)
+			}
+			out[k] = append(list, p)
+		}
+	}
+	return out, nil
+}
+
+func (db *DB) pickTier(from time.Time, step time.Duration) *tier {
+	now := time.Now()
+	var best *tier
+	for _, t := range db.tiers {
+		if now.Sub(from) > t.Retention {
+			continue
+		}
+		if best == nil || t.Resolution <= step {
+			best = t
+		}
+	}
+	if best != nil {
+		return best
+	}
+	best = db.tiers[0]
+	for _, t := range db.tiers[1:] {
+		if t.Retention > best.Retention {
+			best = t
+		}
+	}
+	return best
+}
+
+// Compact syncs open segments and deletes segments past their tier's
+// retention.
+func (db *DB) Compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	for _, t := range db.tiers {
+		if t.seg != nil {
+			if err := t.seg.f.Sync(); err != nil {
+				return err
+			}
+		}
+		segs, err := t.segments()
+		if err != nil {
+			return err
+		}
+		for _, s := range segs {
+			if now.Sub(s.start.Add(t.span)) <= t.Retention {
+				continue
+			}
+			if t.seg != nil && t.seg.path == s.path {
+				continue
+			}
+			if err := os.Remove(s.path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the open segments. Open rollup buckets are not
+// written; they are rebuilt from the tier below on the next Open.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var firstErr error
+	for _, t := range db.tiers {
+		if t.seg != nil {
+			if err := t.seg.close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			t.seg = nil
+		}
+	}
+	return firstErr
+}
+
+// Run persists every snapshot published on hub and compacts the store once
+// a minute until ctx is cancelled.
+func (db *DB) Run(ctx context.Context, hub *sampler.Hub) {
+	ch, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := db.Close(); err != nil {
+				log.Printf("tsdb: close: %v", err)
+			}
+			return
+		case s := <-ch:
+			if err := db.Append(s.Time, s.Series()); err != nil {
+				log.Printf("tsdb: append: %v", err)
+			}
+		case <-ticker.C:
+			if err := db.Compact(); err != nil {
+				log.Printf("tsdb: compact: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package tsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Segments are append-only files of CRC-framed records:
+//
+//	uvarint(len) | uint32le(crc32c(payload)) | payload
+//
+// A payload is either a series definition, which assigns a segment-local id
+// to a series key, or a batch of points sharing one timestamp. A torn write
+// at the tail fails its CRC and is truncated away when the segment is
+// reopened for appending.
+const (
+	recDef    = 'd'
+	recRaw    = 'r'
+	recRollup = 'p'
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorrupt = errors.New("tsdb: corrupt record")
+
+type segment struct {
+	start time.Time
+	path  string
+	f     *os.File
+	ids   map[string]uint64
+}
+
+// record is one decoded points batch.
+type record struct {
+	t      time.Time
+	points map[string]Point
+}
+
+// openSegment opens path for appending, recovering the series dictionary
+// and truncating any partially written tail.
+func openSegment(path string, start time.Time) (*segment, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	seg := &segment{start: start, path: path, f: f, ids: map[string]uint64{}}
+	good, err := scan(f, func(key string, id uint64) {
+		seg.ids[key] = id
+	}, nil)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return seg, nil
+}
+
+// append writes one points batch, defining any series new to this segment.
+func (s *segment) append(t time.Time, points map[string]Point, raw bool) error {
+	var buf []byte
+	for key := range points {
+		if _, ok := s.ids[key]; ok {
+			continue
+		}
+		id := uint64(len(s.ids))
+		p := []byte{recDef}
+		p = binary.AppendUvarint(p, id)
+		p = binary.AppendUvarint(p, uint64(len(key)))
+		p = append(p, key...)
+		buf = frame(buf, p)
+		s.ids[key] = id
+	}
+	p := []byte{recRollup}
+	if raw {
+		p[0] = recRaw
+	}
+	p = binary.AppendVarint(p, t.UnixMilli())
+	p = binary.AppendUvarint(p, uint64(len(points)))
+	for key, pt := range points {
+		p = binary.AppendUvarint(p, s.ids[key])
+		p = binary.LittleEndian.AppendUint64(p, math.Float64bits(pt.Avg))
+		if !raw {
+			p = binary.LittleEndian.AppendUint64(p, math.Float64bits(pt.Min))
+			p = binary.LittleEndian.AppendUint64(p, math.Float64bits(pt.Max))
+			p = binary.AppendUvarint(p, pt.Count)
+		}
+	}
+	buf = frame(buf, p)
+	_, err := s.f.Write(buf)
+	return err
+}
+
+func (s *segment) close() error {
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+func frame(dst, payload []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(payload)))
+	dst = binary.LittleEndian.AppendUint32(dst, crc32.Checksum(payload, crcTable))
+	return append(dst, payload...)
+}
+
+// readSegment decodes every intact record of the segment at path.
+func readSegment(path string, fn func(record)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = scan(f, nil, fn)
+	return err
+}
+
+// scan walks the records of r from the start and returns the offset just
+// past the last intact record. Decoding stops silently at the first torn or
+// corrupt record.
+func scan(r io.ReadSeeker, onDef func(string, uint64), onPoints func(record)) (int64, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	br := bufio.NewReader(r)
+	keys := map[uint64]string{}
+	var off int64
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return off, nil
+		}
+		hdr := int64(uvarintLen(n)) + 4
+		var crc [4]byte
+		if _, err := io.ReadFull(br, crc[:]); err != nil {
+			return off, nil
+		}
+		if n > 1<<24 {
+			return off, nil
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return off, nil
+		}
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(crc[:]) {
+			return off, nil
+		}
+		if err := decode(payload, keys, onDef, onPoints); err != nil {
+			return off, nil
+		}
+		off += hdr + int64(n)
+	}
+}
+
+func decode(p []byte, keys map[uint64]string, onDef func(string, uint64), onPoints func(record)) error {
+	if len(p) == 0 {
+		return errCorrupt
+	}
+	kind, p := p[0], p[1:]
+	switch kind {
+	case recDef:
+		id, n := binary.Uvarint(p)
+		if n <= 0 {
+			return errCorrupt
+		}
+		p = p[n:]
+		l, n := binary.Uvarint(p)
+		if n <= 0 || uint64(len(p)-n) < l {
+			return errCorrupt
+		}
+		key := string(p[n : n+int(l)])
+		keys[id] = key
+		if onDef != nil {
+			onDef(key, id)
+		}
+		return nil
+	case recRaw, recRollup:
+		ms, n := binary.Varint(p)
+		if n <= 0 {
+			return errCorrupt
+		}
+		p = p[n:]
+		count, n := binary.Uvarint(p)
+		if n <= 0 {
+			return errCorrupt
+		}
+		p = p[n:]
+		rec := record{t: time.UnixMilli(ms), points: make(map[string]Point, count)}
+		for i := uint64(0); i < count; i++ {
+			id, n := binary.Uvarint(p)
+			if n <= 0 || len(p)-n < 8 {
+				return errCorrupt
+			}
+			p = p[n:]
+			pt := Point{Time: rec.t, Count: 1}
+			pt.Avg = math.Float64frombits(binary.LittleEndian.Uint64(p))
+			p = p[8:]
+			if kind == recRaw {
+				pt.Min, pt.Max = pt.Avg, pt.Avg
+			} else {
+				if len(p) < 16 {
+					return errCorrupt
+				}
+				pt.Min = math.Float64frombits(binary.LittleEndian.Uint64(p))
+				pt.Max = math.Float64frombits(binary.LittleEndian.Uint64(p[8:]))
+				p = p[16:]
+				c, n := binary.Uvarint(p)
+				if n <= 0 {
+					return errCorrupt
+				}
+				p = p[n:]
+				pt.Count = c
+			}
+			key, ok := keys[id]
+			if !ok {
+				return errCorrupt
+			}
+			rec.points[key] = pt
+		}
+		if onPoints != nil {
+			onPoints(rec)
+		}
+		return nil
+	}
+	return errCorrupt
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
@@ -0,0 +1,254 @@
+// Package users stores the accounts that can log in, each with a role.
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofyr/server_monitor/server/internal/auth"
+	"github.com/gofyr/server_monitor/server/internal/config"
+)
+
+var (
+	ErrNotFound   = errors.New("user not found")
+	ErrExists     = errors.New("user already exists")
+	ErrConfigured = errors.New("user is defined in the config file")
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+// User is an account without its password hash. The account from the
+// config file is always an admin and is changed through
+// change_credentials, not through the store.
+type User struct {
+	Username   string     `json:"username"`
+	Role       string     `json:"role"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	Configured bool       `json:"configured,omitempty"`
+}
+
+// Predates reports whether t is before the account was created, so a
+// session or API token from then belonged to an earlier account of the
+// same name.
+func (u User) Predates(t time.Time) bool {
+	return u.CreatedAt != nil && t.Before(*u.CreatedAt)
+}
+
+type record struct {
+	User
+	PasswordHash string `json:"password_hash"`
+}
+
+// Store keeps users in a JSON file. The file is re-read when it changed on
+// disk, so accounts added with the CLI apply to a running server.
+type Store struct {
+	path string
+	cfg  *config.Config
+
+	mu      sync.Mutex
+	users   map[string]*record
+	modTime time.Time
+}
+
+func Open(path string, cfg *config.Config) (*Store, error) {
+	s := &Store{path: path, cfg: cfg, users: map[string]*record{}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) load() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var list []*record
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("users: %s: %w", s.path, err)
+	}
+	s.users = make(map[string]*record, len(list))
+	for _, u := range list {
+		s.users[u.Username] = u
+	}
+	s.modTime = info.ModTime()
+	return nil
+}
+
+func (s *Store) save() error {
+	list := make([]*record, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+func (s *Store) configured() User {
+	return User{Username: s.cfg.Username, Role: auth.RoleAdmin, Configured: true}
+}
+
+// Authenticate checks a username and password and returns the account.
+func (s *Store) Authenticate(username, password string) (User, bool) {
+	if username == s.cfg.Username {
+		return s.configured(), config.CheckPassword(s.cfg.PasswordHash, password)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	u, ok := s.users[username]
+	if !ok || !config.CheckPassword(u.PasswordHash, password) {
+		return User{}, false
+	}
+	return u.User, true
+}
+
+func (s *Store) Get(username string) (User, error) {
+	if username == s.cfg.Username {
+		return s.configured(), nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	u, ok := s.users[username]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u.User, nil
+}
+
+// List returns every account, the configured one first.
+func (s *Store) List() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	out := []User{s.configured()}
+	for _, u := range s.users {
+		if u.Username != s.cfg.Username {
+			out = append(out, u.User)
+		}
+	}
+	sort.Slice(out[1:], func(i, j int) bool { return out[i+1].Username < out[j+1].Username })
+	return out
+}
+
+func (s *Store) Add(username, password, role string) (User, error) {
+	if !validName.MatchString(username) {
+		return User{}, fmt.Errorf("invalid username %q", username)
+	}
+	if !auth.ValidRole(role) {
+		return User{}, fmt.Errorf("unknown role %q", role)
+	}
+	hash, err := config.HashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return User{}, err
+	}
+	if _, ok := s.users[username]; ok || username == s.cfg.Username {
+		return User{}, ErrExists
+	}
+	now := time.Now().UTC()
+	u := &record{User: User{Username: username, Role: role, CreatedAt: &now}, PasswordHash: hash}
+	s.users[username] = u
+	if err := s.save(); err != nil {
+		delete(s.users, username)
+		return User{}, err
+	}
+	return u.User, nil
+}
+
+// Update changes the password and/or role of an account; empty values are
+// left unchanged.
+func (s *Store) Update(username, password, role string) (User, error) {
+	if username == s.cfg.Username {
+		return User{}, ErrConfigured
+	}
+	if role != "" && !auth.ValidRole(role) {
+		return User{}, fmt.Errorf("unknown role %q", role)
+	}
+	var hash string
+	if password != "" {
+		var err error
+		if hash, err = config.HashPassword(password); err != nil {
+			return User{}, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return User{}, err
+	}
+	u, ok := s.users[username]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	prev := *u
+	if hash != "" {
+		u.PasswordHash = hash
+	}
+	if role != "" {
+		u.Role = role
+	}
+	if err := s.save(); err != nil {
+		*u = prev
+		return User{}, err
+	}
+	return u.User, nil
+}
+
+func (s *Store) Delete(username string) error {
+	if username == s.cfg.Username {
+		return ErrConfigured
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	u, ok := s.users[username]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.users, username)
+	if err := s.save(); err != nil {
+		s.users[username] = u
+		return err
+	}
+	return nil
+}